@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireToken guards endpoints with the reload token, expected as a bearer
+// token. Without a configured token, endpoints are left open unless adminOnly,
+// in which case they are disabled altogether.
+func requireToken(adminOnly bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if *reloadToken == "" {
+			if adminOnly {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "admin endpoints are disabled without a reload token",
+					"code":  "forbidden",
+				})
+			}
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(*reloadToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "missing or invalid token",
+				"code":  "unauthorized",
+			})
+		}
+	}
+}
+
+// bearerToken returns the bearer token of the Authorization header, if any.
+func bearerToken(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return ""
+}
+
+// egressIP asks the configured echo service which IP the server egresses from.
+func egressIP(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *egressURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s replied with %s", *egressURL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("%s replied with an invalid ip address %q", *egressURL, ip)
+	}
+	return ip, nil
+}
+
+// comparison lists the fields for which the primary and compared databases
+// disagree about an IP.
+type comparison struct {
+	IP          string
+	Differences map[string]fieldDiff
+	Error       string `json:",omitempty"`
+}
+
+type fieldDiff struct {
+	Primary, Secondary string
+}
+
+// compareIP looks ip up in both the primary and the configured secondary
+// databases, and reports the differing fields.
+func compareIP(ip string, o options) comparison {
+	type sections struct {
+		AS       *as       `json:",omitempty"`
+		Location *location `json:",omitempty"`
+	}
+	var primary, secondary sections
+	var err error
+	if cmpAsnDB != nil {
+		primary.AS, secondary.AS = new(as), new(as)
+		if *primary.AS, err = getAS(ip, o); err == nil {
+			*secondary.AS, err = lookupAS(cmpAsnDB, ip, o)
+		}
+	}
+	if cmpGeoDB != nil && err == nil {
+		primary.Location, secondary.Location = new(location), new(location)
+		if *primary.Location, err = getLocation(ip, o); err == nil {
+			*secondary.Location, err = lookupLocation(cmpGeoDB, ip, o)
+		}
+	}
+	if err != nil {
+		return comparison{IP: ip, Error: err.Error()}
+	}
+
+	cmp := comparison{IP: ip, Differences: make(map[string]fieldDiff)}
+	p, _ := flatten(primary)
+	s, _ := flatten(secondary)
+	for k, v := range p {
+		if s[k] != v {
+			cmp.Differences[k] = fieldDiff{Primary: v, Secondary: s[k]}
+		}
+	}
+	for k, v := range s {
+		if _, ok := p[k]; !ok {
+			cmp.Differences[k] = fieldDiff{Secondary: v}
+		}
+	}
+	return cmp
+}
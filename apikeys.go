@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// apiKeys maps API keys to the name of their client; API key authentication
+// is disabled when there are none.
+var apiKeys map[string]string
+
+var apiKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ipinfo_api_key_requests_total",
+	Help: "Number of authenticated HTTP requests by API key name.",
+}, []string{"key"})
+
+// parseAPIKeys parses "name:key" pairs, separated by commas or new lines.
+func parseAPIKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" || strings.HasPrefix(pair, "#") {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid api key %q, expected name:key", pair)
+		}
+		keys[strings.TrimSpace(parts[1])] = strings.TrimSpace(parts[0])
+	}
+	return keys, nil
+}
+
+// loadAPIKeys reads the keys given inline and in file, if any.
+func loadAPIKeys(inline, file string) (map[string]string, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		inline += "\n" + string(b)
+	}
+	return parseAPIKeys(inline)
+}
+
+// apiKeyName is the context key of the name of the API key of a request.
+const apiKeyName = "api_key_name"
+
+// requireAPIKey rejects requests without a valid X-API-Key header, and counts
+// the requests of every client.
+func requireAPIKey(c *gin.Context) {
+	key := c.GetHeader("X-API-Key")
+	for k, name := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			apiKeyRequests.WithLabelValues(name).Inc()
+			c.Set(apiKeyName, name)
+			return
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"error": "missing or invalid api key",
+		"code":  "unauthorized",
+	})
+}
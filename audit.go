@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditLog records the lookups made, when configured with -audit_log. Entries
+// are written by a goroutine of their own, for the lookups not to wait on the
+// file; they only block once auditBuffer entries are pending.
+type auditLog struct {
+	entries   chan auditEntry
+	anonymize bool
+
+	mu     sync.RWMutex // guards closed, against lookups outliving shutdown
+	closed bool
+	done   chan struct{} // once every entry is written
+}
+
+const auditBuffer = 4096
+
+// audit is nil when audit logging is disabled.
+var audit *auditLog
+
+type auditEntry struct {
+	Time    string `json:"time"`
+	Client  string `json:"client"`
+	APIKey  string `json:"api_key,omitempty"` // name of the client's key
+	Kind    string `json:"kind"`
+	Query   string `json:"query"`
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// openAuditLog appends entries to the named file, or writes them to stdout for
+// "-". Client and queried IPs are truncated to their /24 (IPv4) or /48 (IPv6)
+// network when anonymizing.
+func openAuditLog(name string, anonymize bool) (*auditLog, error) {
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	a := &auditLog{entries: make(chan auditEntry, auditBuffer), anonymize: anonymize, done: make(chan struct{})}
+	go func() {
+		defer close(a.done)
+		enc := json.NewEncoder(w)
+		for e := range a.entries {
+			if err := enc.Encode(e); err != nil {
+				log.Printf("audit log: %v", err)
+			}
+		}
+	}()
+	return a, nil
+}
+
+// record logs the lookup of query (an IP, or a hostname for forward lookups)
+// by the client of c, along with the country and ASN of its result if any.
+func (a *auditLog) record(c *gin.Context, kind, query string, result interface{}, err error) {
+	if a == nil {
+		return
+	}
+	e := auditEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Client: a.ip(clientIP(c)),
+		APIKey: c.GetString(apiKeyName),
+		Kind:   kind,
+		Query:  a.ip(query),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	switch r := result.(type) {
+	case as:
+		e.ASN = r.Number
+	case location:
+		e.Country = r.CountryCode
+	case ipinfo:
+		if r.AS != nil {
+			e.ASN = r.AS.Number
+		}
+		if r.Location != nil {
+			e.Country = r.Location.CountryCode
+		}
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !a.closed {
+		a.entries <- e
+	}
+}
+
+// close waits for the pending entries to be written; later ones are dropped.
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.closed = true
+	close(a.entries)
+	a.mu.Unlock()
+	<-a.done
+}
+
+// ip anonymizes s when configured to and s is an IP, leaving it as is otherwise.
+func (a *auditLog) ip(s string) string {
+	ip := net.ParseIP(s)
+	if !a.anonymize || ip == nil {
+		return s
+	}
+	return truncateIP(ip)
+}
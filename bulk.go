@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// bulkEntry is the result of one of the IPs of a bulk request; errors are
+// reported per entry and never fail the whole batch.
+type bulkEntry struct {
+	IP       string
+	Result   *ipinfo `json:",omitempty"`
+	Error    string  `json:",omitempty"`
+	PTRError string  `json:",omitempty"` // with ?ptr=true, see resolvePTRs
+	Prefix   string  `json:",omitempty"` // with ?prefix=true, see prefixTag
+	Tag      string  `json:",omitempty"`
+}
+
+// asnEntry is the compact result of one of the IPs of a ?type=asn bulk
+// request, for ASN-only enrichment.
+type asnEntry struct {
+	IP         string
+	ASN        uint     `json:",omitempty"`
+	Org        string   `json:",omitempty"`
+	Normalized string   `json:",omitempty"` // Org, with -org_normalization
+	Hostnames  []string `json:",omitempty"` // with ?ptr=true
+	Error      string   `json:",omitempty"`
+	PTRError   string   `json:",omitempty"`
+	Prefix     string   `json:",omitempty"`
+	Tag        string   `json:",omitempty"`
+}
+
+// bulkGroup is the entries of a ?group_by= bulk request sharing a key.
+type bulkGroup struct {
+	Count   int
+	Entries []interface{}
+}
+
+// groupUnknown is the key of the entries with no data to be grouped by.
+const groupUnknown = "unknown"
+
+// addToGroup appends entry to the group of key, creating it as needed.
+func addToGroup(groups map[string]*bulkGroup, key string, entry interface{}) {
+	if key == "" {
+		key = groupUnknown
+	}
+	g, ok := groups[key]
+	if !ok {
+		g = &bulkGroup{}
+		groups[key] = g
+	}
+	g.Count++
+	g.Entries = append(g.Entries, entry)
+}
+
+// bulk looks up every IP of a JSON array posted as the request body, or of
+// a text/plain body with one IP per line (blank lines and #comments ignored).
+func bulk(c *gin.Context) {
+	o := requestOptions(c)
+
+	var ips []string
+	var err error
+	if c.ContentType() == gin.MIMEPlain {
+		// Not read past the limit, however large the body
+		ips, err = readLines(c.Request.Body, *maxBulk)
+	} else {
+		err = c.ShouldBindJSON(&ips)
+	}
+	if _, dateErr := requestDate(c); dateErr != nil && len(historyDBs) > 0 {
+		err = dateErr
+	}
+	if err != nil {
+		render(c, o, http.StatusBadRequest, gin.H{"error": err.Error(), "code": "bad_request"})
+		return
+	}
+	if len(ips) > *maxBulk {
+		render(c, o, http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("at most %d ip addresses can be looked up at once", *maxBulk),
+			"code":  "too_many_ips",
+		})
+		return
+	}
+	for i, ip := range ips {
+		ips[i] = normalizeIP(ip)
+	}
+	lookupAll(c, o, ips)
+}
+
+// lookupAll looks up every IP and renders the results, as a list of entries
+// in input order. With ?type=asn, only the AS number and organization of each
+// IP are returned. With ?group_by=country or asn, entries are instead nested
+// under their country code or AS number, along with their count; entries
+// without one (including errors) are grouped under "unknown". Repeated IPs are
+// only looked up once, unless disabled with ?dedup=false, their entries still
+// being repeated in the response. With ?ptr=true, the hostnames of the IPs are
+// also looked up, see resolvePTRs. With ?prefix=true, entries get the prefix
+// they are shared by and its tag, see prefixTag. In the default -bulk_consistency snapshot
+// mode, every IP is looked up in the same database readers, including when
+// they are reloaded midway.
+func lookupAll(c *gin.Context, o options, ips []string) {
+	o.hostnames = false
+	// Parameters are all validated before any lookup, DNS ones included
+	kind := c.DefaultQuery("type", "all")
+	if kind != "all" && kind != "asn" {
+		render(c, o, http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid bulk lookup type %q", kind),
+			"code":  "bad_request",
+		})
+		return
+	}
+	groupBy := c.Query("group_by")
+	if groupBy != "" && groupBy != "asn" && (groupBy != "country" || kind == "asn") {
+		render(c, o, http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid grouping %q for bulk lookup type %q", groupBy, kind),
+			"code":  "bad_request",
+		})
+		return
+	}
+	prefix, _ := strconv.ParseBool(c.Query("prefix"))
+	dedup := *bulkDedup
+	if d, err := strconv.ParseBool(c.Query("dedup")); err == nil {
+		dedup = d
+	}
+	ptr, _ := strconv.ParseBool(c.Query("ptr"))
+
+	if *bulkConsistency == "snapshot" {
+		var release func()
+		o.readers, release = pinReaders()
+		defer release()
+	}
+	var ptrs map[string]ptrResult
+	if ptr && !o.redacted["hostnames"] {
+		ptrs = resolvePTRs(c.Request.Context(), o, ips)
+	}
+	// Index of the first entry of each IP, which repeated ones are copied from
+	first := make(map[string]int)
+
+	if kind == "asn" {
+		entries := make([]asnEntry, len(ips))
+		for i, ip := range ips {
+			if j, ok := first[ip]; ok && dedup {
+				entries[i] = entries[j]
+				continue
+			}
+			first[ip] = i
+			entries[i].IP = ip
+			asn, err := getAS(ip, o)
+			audit.record(c, kind, ip, asn, err)
+			if err != nil {
+				entries[i].Error = err.Error()
+			} else {
+				entries[i].ASN, entries[i].Org, entries[i].Normalized = asn.Number, asn.Name, asn.Normalized
+				if prefix {
+					entries[i].Prefix, entries[i].Tag = prefixTag(o, ip, []*database{asnDB, enterpriseDB})
+				}
+			}
+			if r, ok := ptrs[ip]; ok {
+				entries[i].Hostnames, entries[i].PTRError = r.hostnames, r.error()
+			}
+		}
+		if groupBy == "" {
+			render(c, o, http.StatusOK, entries)
+			return
+		}
+		groups := make(map[string]*bulkGroup)
+		for _, e := range entries {
+			addToGroup(groups, asnKey(e.ASN), e)
+		}
+		render(c, o, http.StatusOK, groups)
+		return
+	}
+
+	entries := make([]bulkEntry, len(ips))
+	for i, ip := range ips {
+		if j, ok := first[ip]; ok && dedup {
+			entries[i] = entries[j]
+			continue
+		}
+		first[ip] = i
+		entries[i].IP = ip
+		info, err := getIPInfo(ip, o)
+		audit.record(c, kind, ip, info, err)
+		if r, ok := ptrs[ip]; ok {
+			info.Hostnames, entries[i].PTRError = r.hostnames, r.error()
+		}
+		if err != nil {
+			entries[i].Error = err.Error()
+		} else {
+			entries[i].Result = &info
+			if prefix {
+				entries[i].Prefix, entries[i].Tag = prefixTag(o, ip, enrichedDBs(o))
+			}
+		}
+	}
+	if groupBy == "" {
+		render(c, o, http.StatusOK, entries)
+		return
+	}
+	groups := make(map[string]*bulkGroup)
+	for _, e := range entries {
+		var key string
+		switch {
+		case e.Result == nil:
+		case groupBy == "country" && e.Result.Location != nil:
+			key = e.Result.Location.CountryCode
+		case groupBy == "asn" && e.Result.AS != nil:
+			key = asnKey(e.Result.AS.Number)
+		}
+		addToGroup(groups, key, toVersion(e, o.version))
+	}
+	render(c, o, http.StatusOK, groups)
+}
+
+// prefixTag returns the prefix of ip whose IPs share its entry, the most
+// specific of the networks ip matches in dbs, along with a tag of it and of the
+// build dates of dbs: clients can cache entries by prefix, as long as their tag
+// does not change. Overrides more specific than the prefix are not accounted
+// for, nor are hostnames.
+func prefixTag(o options, ip string, dbs []*database) (string, string) {
+	ipaddr := net.ParseIP(ip)
+	var prefix *net.IPNet
+	longest := -1
+	h := sha256.New()
+	for _, db := range dbs {
+		if db == nil {
+			continue
+		}
+		r := o.reader(db)
+		if r == nil || !r.contains(ipaddr) {
+			continue
+		}
+		fmt.Fprintf(h, "%s=%d\n", db.name, r.Metadata().BuildEpoch)
+		_, network, err := net.ParseCIDR(r.network(ipaddr))
+		if err != nil {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones > longest {
+			prefix, longest = network, ones
+		}
+	}
+	if prefix == nil {
+		return "", ""
+	}
+	fmt.Fprintf(h, "%s\n", prefix)
+	return prefix.String(), hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// enrichedDBs returns the databases the enrichers of o read from.
+func enrichedDBs(o options) []*database {
+	var dbs []*database
+	if o.enrich["asn"] {
+		dbs = append(dbs, asnDB)
+	}
+	if o.enrich["geo"] {
+		dbs = append(append(dbs, geoDB), geoFallbacks...)
+	}
+	if o.enrich["asn"] || o.enrich["geo"] {
+		dbs = append(dbs, enterpriseDB)
+	}
+	// In a fixed order, for tags not to depend on it
+	optional := []struct {
+		enricher string
+		db       *database
+	}{{"isp", ispDB}, {"anon", anonDB}, {"domain", domainDB}, {"traits", traitsDB()}}
+	for _, e := range optional {
+		if o.enrich[e.enricher] {
+			dbs = append(dbs, e.db)
+		}
+	}
+	return dbs
+}
+
+// ptrResult is the outcome of the reverse DNS lookup of a bulk IP.
+type ptrResult struct {
+	hostnames []string
+	err       error
+}
+
+func (r ptrResult) error() string {
+	if r.err == nil {
+		return ""
+	}
+	return r.err.Error()
+}
+
+// resolvePTRs looks up the hostnames of every distinct IP of ips, by up to
+// -bulk_ptr_concurrency at once and within -bulk_ptr_timeout overall: IPs not
+// resolved by then get a timeout error, failed lookups only failing their own
+// entry. Hostnames are cached along with lookup results. Since each lookup
+// takes a DNS round trip (up to -dns_timeout for unresponsive servers), large
+// batches take about len(ips)/-bulk_ptr_concurrency round trips more.
+func resolvePTRs(ctx context.Context, o options, ips []string) map[string]ptrResult {
+	ctx, cancel := context.WithTimeout(ctx, *bulkPTRTimeout)
+	defer cancel()
+	results := make(map[string]ptrResult, len(ips))
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(*bulkPTRConcurrency)
+	seen := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		if seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		ip := ip
+		g.Go(func() error {
+			var r ptrResult
+			r.err = cachedLookup(o, "ptr", ip, &r.hostnames, func() (err error) {
+				r.hostnames, err = lookupPTR(ctx, ip)
+				return err
+			})
+			mu.Lock()
+			results[ip] = r
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
+
+// asnKey is the group key of an AS number, none for 0.
+func asnKey(asn uint) string {
+	if asn == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(asn), 10)
+}
+
+// readLines returns the trimmed lines of r, skipping blank lines and comments.
+// Reading stops past max lines (unless negative), the max+1 first ones being
+// returned for callers to tell there are too many.
+func readLines(r io.Reader, max int) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for (max < 0 || len(lines) <= max) && scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
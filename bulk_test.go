@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bulkRequest posts body to the bulk handler with the given query string.
+func bulkRequest(contentType, body, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/bulk?"+query, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", contentType)
+	bulk(c)
+	return w
+}
+
+func TestBulkValidatesBeforeLookups(t *testing.T) {
+	var dials int32
+	defer func(r *net.Resolver) { resolver = r }(resolver)
+	resolver = &net.Resolver{PreferGo: true, Dial: func(context.Context, string, string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return nil, errors.New("no dns in tests")
+	}}
+	for _, query := range []string{"ptr=true&type=bogus", "ptr=true&group_by=bogus", "ptr=true&type=asn&group_by=country"} {
+		if w := bulkRequest(gin.MIMEJSON, `["8.8.8.8","1.1.1.1"]`, query); w.Code != http.StatusBadRequest {
+			t.Errorf("bulk ?%s status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+	if dials != 0 {
+		t.Errorf("invalid bulk requests made %d dns queries, want none", dials)
+	}
+}
+
+func TestBulkPlainTextLimit(t *testing.T) {
+	defer func(n int) { *maxBulk = n }(*maxBulk)
+	*maxBulk = 2
+	if w := bulkRequest(gin.MIMEPlain, "8.8.8.8\n# comment\n\n1.1.1.1\n", ""); w.Code != http.StatusOK {
+		t.Errorf("bulk of %d lines status = %d, want %d", *maxBulk, w.Code, http.StatusOK)
+	}
+	lines, err := readLines(strings.NewReader(strings.Repeat("8.8.8.8\n", 1000)), *maxBulk)
+	if err != nil || len(lines) != *maxBulk+1 {
+		t.Errorf("readLines read %d lines (error %v), want %d", len(lines), err, *maxBulk+1)
+	}
+	if w := bulkRequest(gin.MIMEPlain, strings.Repeat("8.8.8.8\n", 1000), ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("bulk of 1000 lines status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
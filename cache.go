@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// resultCache holds lookup results, when enabled (nil otherwise).
+var resultCache cache
+
+// cache stores JSON encoded lookup results for a TTL. Results are invalidated
+// as a whole whenever a database is reloaded.
+type cache interface {
+	get(ctx context.Context, key string) ([]byte, bool)
+	set(ctx context.Context, key string, value []byte)
+	invalidate(ctx context.Context)
+}
+
+// maxMemoryEntries bounds the in-memory cache, which is emptied once full.
+const maxMemoryEntries = 100000
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryCache is a per-instance cache.
+type memoryCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func newMemoryCache(ttl time.Duration) *memoryCache {
+	return &memoryCache{ttl: ttl, entries: make(map[string]memoryEntry)}
+}
+
+func (m *memoryCache) get(_ context.Context, key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (m *memoryCache) set(_ context.Context, key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.entries) >= maxMemoryEntries {
+		m.entries = make(map[string]memoryEntry)
+	}
+	m.entries[key] = memoryEntry{value: value, expires: time.Now().Add(m.ttl)}
+}
+
+func (m *memoryCache) invalidate(context.Context) {
+	m.mu.Lock()
+	m.entries = make(map[string]memoryEntry)
+	m.mu.Unlock()
+}
+
+// redisVersionKey is bumped on every reload; it prefixes all the keys, so
+// that instances sharing the cache stop reading stale results at once.
+const redisVersionKey = "ipinfo:cache:version"
+
+// redisCache is shared between instances. Redis errors are logged and treated
+// as cache misses, lookups never fail because of the cache.
+type redisCache struct {
+	ttl    time.Duration
+	client *redis.Client
+}
+
+func newRedisCache(addr string, ttl time.Duration) *redisCache {
+	return &redisCache{ttl: ttl, client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (r *redisCache) versioned(ctx context.Context, key string) (string, error) {
+	version, err := r.client.Get(ctx, redisVersionKey).Int64()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return fmt.Sprintf("ipinfo:v%d:%s", version, key), nil
+}
+
+func (r *redisCache) get(ctx context.Context, key string) ([]byte, bool) {
+	key, err := r.versioned(ctx, key)
+	if err != nil {
+		log.Printf("redis cache: %v", err)
+		return nil, false
+	}
+	value, err := r.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("redis cache: %v", err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (r *redisCache) set(ctx context.Context, key string, value []byte) {
+	key, err := r.versioned(ctx, key)
+	if err == nil {
+		err = r.client.Set(ctx, key, value, r.ttl).Err()
+	}
+	if err != nil {
+		log.Printf("redis cache: %v", err)
+	}
+}
+
+func (r *redisCache) invalidate(ctx context.Context) {
+	if err := r.client.Incr(ctx, redisVersionKey).Err(); err != nil {
+		log.Printf("redis cache: %v", err)
+	}
+}
+
+// cacheKey identifies the result of a lookup of ip by the IP and language(s),
+// along with every other option changing the result.
+func cacheKey(kind, ip string, o options) string {
+	if ipaddr := net.ParseIP(ip); ipaddr != nil {
+		ip = ipaddr.String()
+	}
+	fieldLangs := make([]string, 0, len(o.fieldLangs))
+	for field, l := range o.fieldLangs {
+		fieldLangs = append(fieldLangs, field+"="+l)
+	}
+	sort.Strings(fieldLangs)
+	return fmt.Sprintf("%s:%s:%s:%s:%t:%t:%t:%t:%t:%t:%t:%t:%t:%d:%d:%s:%s:%d", kind, ip, strings.Join(o.langs, ","), strings.Join(fieldLangs, ","), o.ascii, o.asnFormats, o.formatted, o.fingerprint, o.approximate, o.nearestCity,
+		o.hostnames, o.network, o.addressType, o.subdivisionDepth, o.geohash, setKey(o.enrich), setKey(o.redacted), o.date.Unix())
+}
+
+func setKey(set map[string]bool) string {
+	keys := make([]string, 0, len(set))
+	for k, ok := range set {
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// inflight coalesces concurrent identical lookups, with -coalesce_lookups.
+var inflight singleflight.Group
+
+// cachedLookup decodes the cached result of a lookup into v, or calls lookup
+// (which must fill v in) and caches its result. Errors aren't cached, nor are
+// debugging results or local times. With -coalesce_lookups, concurrent
+// identical lookups missing the cache (as for a popular IP) are only run once,
+// sharing its result or error; the cache alone would have all of them read the
+// database until the first one completes.
+func cachedLookup(o options, kind, ip string, v interface{}, lookup func() error) error {
+	if (resultCache == nil && !*coalesceLookups) || o.debug || o.localTime {
+		return lookup()
+	}
+	key := cacheKey(kind, ip, o)
+	if resultCache != nil {
+		if b, ok := resultCache.get(o.ctx, key); ok && json.Unmarshal(b, v) == nil {
+			return nil
+		}
+	}
+	if !*coalesceLookups {
+		if err := lookup(); err != nil {
+			return err
+		}
+		if b, err := json.Marshal(v); err == nil {
+			resultCache.set(o.ctx, key, b)
+		}
+		return nil
+	}
+	// Only the leader runs lookup, filling in its own v
+	var leader bool
+	var lookupErr error
+	b, err, _ := inflight.Do(key, func() (interface{}, error) {
+		leader = true
+		if lookupErr = lookup(); lookupErr != nil {
+			return nil, lookupErr
+		}
+		b, err := json.Marshal(v)
+		if err == nil && resultCache != nil {
+			resultCache.set(o.ctx, key, b)
+		}
+		return b, err
+	})
+	if leader {
+		return lookupErr
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b.([]byte), v)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkCoalescedLookups has herds of concurrent lookups of the same
+// uncached IP, reporting the database reads per lookup with and without
+// -coalesce_lookups. Reads are slowed down to those of database pages not in
+// memory yet, the fixtures being small enough to always be.
+func BenchmarkCoalescedLookups(b *testing.B) {
+	const herd, coldRead = 32, 100 * time.Microsecond
+	defer func(coalesce bool) { *coalesceLookups = coalesce }(*coalesceLookups)
+	defer func(c cache) { resultCache = c }(resultCache)
+	resultCache = nil
+	for _, coalesce := range []bool{false, true} {
+		*coalesceLookups = coalesce
+		b.Run(fmt.Sprintf("coalesce=%t", coalesce), func(b *testing.B) {
+			var reads int64
+			o := testOptions()
+			for i := 0; i < b.N; i++ {
+				start := make(chan struct{})
+				var wg sync.WaitGroup
+				for j := 0; j < herd; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						<-start
+						var asn as
+						err := cachedLookup(o, "asn", "8.8.8.8", &asn, func() (err error) {
+							atomic.AddInt64(&reads, 1)
+							time.Sleep(coldRead)
+							asn, err = getAS("8.8.8.8", o)
+							return err
+						})
+						if err != nil {
+							b.Error(err)
+						}
+					}()
+				}
+				close(start)
+				wg.Wait()
+			}
+			b.ReportMetric(float64(reads)/float64(b.N*herd), "reads/lookup")
+		})
+	}
+}
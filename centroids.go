@@ -0,0 +1,40 @@
+package main
+
+// centroid is the approximate center of a country or continent.
+type centroid struct{ lat, long float64 }
+
+// countryCentroids are those of the countries IPs are most often located in,
+// by ISO 3166-1 alpha-2 code.
+var countryCentroids = map[string]centroid{
+	"AR": {-38.42, -63.62}, "AU": {-25.27, 133.78}, "BR": {-14.24, -51.93}, "CA": {56.13, -106.35},
+	"CN": {35.86, 104.20}, "DE": {51.17, 10.45}, "EG": {26.82, 30.80}, "ES": {40.46, -3.75},
+	"FR": {46.23, 2.21}, "GB": {55.38, -3.44}, "ID": {-0.79, 113.92}, "IN": {20.59, 78.96},
+	"IT": {41.87, 12.57}, "JP": {36.20, 138.25}, "KR": {35.91, 127.77}, "MX": {23.63, -102.55},
+	"NG": {9.08, 8.68}, "NL": {52.13, 5.29}, "PL": {51.92, 19.15}, "RU": {61.52, 105.32},
+	"SE": {60.13, 18.64}, "TR": {38.96, 35.24}, "UA": {48.38, 31.17}, "US": {39.83, -98.58},
+	"ZA": {-30.56, 22.94},
+}
+
+// continentCentroids are keyed by continent code.
+var continentCentroids = map[string]centroid{
+	"AF": {7.19, 21.09}, "AN": {-82.86, 135.00}, "AS": {34.05, 100.62}, "EU": {54.53, 15.25},
+	"NA": {46.07, -100.55}, "OC": {-22.74, 140.02}, "SA": {-14.60, -57.66},
+}
+
+// approximateCoords sets the coordinates of loc to the centroid of its country
+// or else of its continent, if known, recording which in loc.Approximated.
+// They're rounded as database coordinates are, with -coord_precision.
+func approximateCoords(loc *location) {
+	c, ok := countryCentroids[loc.CountryCode]
+	loc.Approximated = "country"
+	if !ok {
+		c, ok = continentCentroids[loc.ContinentCode]
+		loc.Approximated = "continent"
+	}
+	if !ok {
+		loc.Approximated = ""
+		return
+	}
+	loc.Latitude, loc.Longitude = &c.lat, &c.long
+	loc.roundCoords()
+}
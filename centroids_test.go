@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestApproximateCoords(t *testing.T) {
+	defer func(digits int) { *coordPrecision = digits }(*coordPrecision)
+	o := testOptions()
+	o.approximate = true
+	tests := []struct {
+		digits    int
+		lat, long float64
+	}{
+		{-1, 51.17, 10.45},
+		{1, 51.2, 10.5},
+		{0, 51, 10},
+	}
+	for _, tt := range tests {
+		*coordPrecision = tt.digits
+		// 9.9.9.9 is in DE, without coordinates
+		loc, err := getLocation("9.9.9.9", o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc.Approximated != "country" || loc.Latitude == nil || loc.Longitude == nil {
+			t.Fatalf("getLocation(9.9.9.9) = %+v, want the coordinates of the country centroid", loc)
+		}
+		if *loc.Latitude != tt.lat || *loc.Longitude != tt.long {
+			t.Errorf("approximated coordinates with -coord_precision=%d = %v, %v; want %v, %v", tt.digits, *loc.Latitude, *loc.Longitude, tt.lat, tt.long)
+		}
+	}
+	// Centroids aren't rounded in place
+	if c := countryCentroids["DE"]; c.lat != 51.17 || c.long != 10.45 {
+		t.Errorf("DE centroid = %v, want it unchanged", c)
+	}
+}
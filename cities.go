@@ -0,0 +1,72 @@
+package main
+
+import "math"
+
+// city is a major city, nearest ones being derived from coordinates.
+type city struct {
+	name, country string
+	lat, long     float64
+}
+
+// majorCities is the reference set of ?nearest_city=true, with English names.
+var majorCities = []city{
+	{"Amsterdam", "NL", 52.37, 4.90}, {"Atlanta", "US", 33.75, -84.39}, {"Bangkok", "TH", 13.76, 100.50},
+	{"Beijing", "CN", 39.90, 116.41}, {"Berlin", "DE", 52.52, 13.40}, {"Bogotá", "CO", 4.71, -74.07},
+	{"Buenos Aires", "AR", -34.60, -58.38}, {"Cairo", "EG", 30.04, 31.24}, {"Chicago", "US", 41.88, -87.63},
+	{"Dallas", "US", 32.78, -96.80}, {"Delhi", "IN", 28.70, 77.10}, {"Dubai", "AE", 25.20, 55.27},
+	{"Frankfurt", "DE", 50.11, 8.68}, {"Hong Kong", "HK", 22.32, 114.17}, {"Istanbul", "TR", 41.01, 28.98},
+	{"Jakarta", "ID", -6.21, 106.85}, {"Johannesburg", "ZA", -26.20, 28.05}, {"Kyiv", "UA", 50.45, 30.52},
+	{"Lagos", "NG", 6.52, 3.38}, {"Lima", "PE", -12.05, -77.04}, {"London", "GB", 51.51, -0.13},
+	{"Los Angeles", "US", 34.05, -118.24}, {"Madrid", "ES", 40.42, -3.70}, {"Melbourne", "AU", -37.81, 144.96},
+	{"Mexico City", "MX", 19.43, -99.13}, {"Miami", "US", 25.76, -80.19}, {"Milan", "IT", 45.46, 9.19},
+	{"Montreal", "CA", 45.50, -73.57}, {"Moscow", "RU", 55.76, 37.62}, {"Mumbai", "IN", 19.08, 72.88},
+	{"Nairobi", "KE", -1.29, 36.82}, {"New York", "US", 40.71, -74.01}, {"Paris", "FR", 48.86, 2.35},
+	{"Rome", "IT", 41.90, 12.50}, {"San Francisco", "US", 37.77, -122.42}, {"Santiago", "CL", -33.45, -70.67},
+	{"São Paulo", "BR", -23.55, -46.63}, {"Seattle", "US", 47.61, -122.33}, {"Seoul", "KR", 37.57, 126.98},
+	{"Shanghai", "CN", 31.23, 121.47}, {"Singapore", "SG", 1.35, 103.82}, {"Stockholm", "SE", 59.33, 18.07},
+	{"Sydney", "AU", -33.87, 151.21}, {"Tokyo", "JP", 35.68, 139.69}, {"Toronto", "CA", 43.65, -79.38},
+	{"Vienna", "AT", 48.21, 16.37}, {"Warsaw", "PL", 52.23, 21.01}, {"Washington", "US", 38.91, -77.04},
+}
+
+// maxNearestCityKm is the distance past which no major city is near enough.
+const maxNearestCityKm = 300
+
+// nearestCity is a major city close to coordinates lacking a named city.
+type nearestCity struct {
+	Name        localized
+	CountryCode string
+	DistanceKm  float64
+}
+
+// setNearestCity derives the nearest major city of loc from its coordinates,
+// when the database has no city name for them; the City field itself is left
+// as is, for clients not to mistake the approximation for database data.
+func (o options) setNearestCity(loc *location) {
+	if !loc.hasCoords() || loc.Approximated != "" {
+		return
+	}
+	best, bestKm := -1, math.Inf(1)
+	for i, c := range majorCities {
+		if km := distanceKm(*loc.Latitude, *loc.Longitude, c.lat, c.long); km < bestKm {
+			best, bestKm = i, km
+		}
+	}
+	if best < 0 || bestKm > maxNearestCityKm {
+		return
+	}
+	c := majorCities[best]
+	loc.NearestCity = &nearestCity{
+		Name:        o.langFor("city").localize(map[string]string{defaultLang: c.name}),
+		CountryCode: c.country,
+		DistanceKm:  math.Round(bestKm),
+	}
+}
+
+// distanceKm is the great-circle distance between two points (haversine).
+func distanceKm(lat1, long1, lat2, long2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat, dLong := (lat2-lat1)*rad, (long2-long1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyNets are the trusted proxies, parsed from -trusted_proxies.
+var proxyNets []*net.IPNet
+
+// clientIPHeaders are the client IP headers, by order of precedence.
+var clientIPHeaders []string
+
+// parseProxies parses comma-separated IPs and CIDRs, skipping invalid ones.
+func parseProxies(s string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, p := range splitList(s) {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip.To4() != nil {
+				nets = append(nets, &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)})
+			} else if ip != nil {
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// clientIP returns the IP of the client. Client IP headers are only honored
+// from trusted proxies, by order of precedence. In the default "trusted" mode,
+// comma-separated chains (e.g. X-Forwarded-For: client, proxy1, proxy2) are
+// walked from right to left, since each proxy appends the address it was
+// reached from, skipping trusted proxies: the first untrusted address is the
+// client, whatever the client itself put in the header. In "first" mode, the
+// leftmost address is taken as-is. Headers with invalid addresses are ignored.
+func clientIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	remote := net.ParseIP(host)
+	if err != nil || remote == nil {
+		return ""
+	}
+	if !inNets(remote, proxyNets) {
+		return remote.String()
+	}
+	for _, header := range clientIPHeaders {
+		if ip := chainClient(c.GetHeader(header)); ip != nil {
+			return ip.String()
+		}
+	}
+	return remote.String()
+}
+
+// chainClient returns the rightmost untrusted address of a proxy chain, or
+// its leftmost one if they are all trusted or in "first" mode; nil if the
+// chain is invalid.
+func chainClient(chain string) net.IP {
+	if chain == "" {
+		return nil
+	}
+	hops := strings.Split(chain, ",")
+	ips := make([]net.IP, len(hops))
+	for i, hop := range hops {
+		if ips[i] = net.ParseIP(strings.TrimSpace(hop)); ips[i] == nil {
+			return nil
+		}
+	}
+	if *forwardedFor == "first" {
+		return ips[0]
+	}
+	for i := len(ips) - 1; i > 0; i-- {
+		if !inNets(ips[i], proxyNets) {
+			return ips[i]
+		}
+	}
+	return ips[0]
+}
+
+// resolveClient replaces the remote address of requests by that of their
+// client, for Gin (whose own resolution is only set up by Engine.Run) and the
+// access logs to agree with clientIP, which gives the same client again.
+func resolveClient(c *gin.Context) {
+	if _, port, err := net.SplitHostPort(c.Request.RemoteAddr); err == nil {
+		if ip := clientIP(c); ip != "" {
+			c.Request.RemoteAddr = net.JoinHostPort(ip, port)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(nets []*net.IPNet, headers []string, mode string) {
+		proxyNets, clientIPHeaders, *forwardedFor = nets, headers, mode
+	}(proxyNets, clientIPHeaders, *forwardedFor)
+	proxyNets, clientIPHeaders = parseProxies("10.0.0.0/8,192.0.2.1"), []string{"X-Forwarded-For"}
+	tests := []struct {
+		mode, remote, xff, want string
+	}{
+		{"trusted", "203.0.113.7:1234", "1.1.1.1", "203.0.113.7"}, // untrusted peer, header ignored
+		{"trusted", "10.0.0.1:1234", "", "10.0.0.1"},
+		{"trusted", "10.0.0.1:1234", "8.8.8.8", "8.8.8.8"},
+		{"trusted", "10.0.0.1:1234", "8.8.8.8, 10.1.1.1, 192.0.2.1", "8.8.8.8"},
+		{"trusted", "10.0.0.1:1234", "6.6.6.6, 8.8.8.8, 10.1.1.1", "8.8.8.8"}, // spoofed leftmost entry
+		{"trusted", "10.0.0.1:1234", "6.6.6.6, 8.8.8.8, 1.1.1.1, 10.1.1.1", "1.1.1.1"},
+		{"trusted", "10.0.0.1:1234", "10.2.2.2, 10.1.1.1", "10.2.2.2"}, // all trusted
+		{"trusted", "10.0.0.1:1234", "8.8.8.8, bogus", "10.0.0.1"},
+		{"trusted", "[2001:db8::1]:1234", "8.8.8.8", "2001:db8::1"},
+		{"first", "10.0.0.1:1234", "6.6.6.6, 8.8.8.8, 10.1.1.1", "6.6.6.6"},
+	}
+	for _, tt := range tests {
+		*forwardedFor = tt.mode
+		headers := map[string]string{}
+		if tt.xff != "" {
+			headers["X-Forwarded-For"] = tt.xff
+		}
+		c, _ := testContext("/myip", headers)
+		c.Request.RemoteAddr = tt.remote
+		if got := clientIP(c); got != tt.want {
+			t.Errorf("%s mode, from %s with X-Forwarded-For %q: client %s, want %s", tt.mode, tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
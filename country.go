@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// countryPriority ranks the city databases by name with -country_priority,
+// for -country_resolution; the ones left out come after, in lookup order.
+var countryPriority []string
+
+// countryVote is the country a database has for an IP, weighed by its
+// confidence (from 1 to 100, in Enterprise databases; 0 when unknown).
+type countryVote struct {
+	Database   string
+	Country    string
+	Confidence uint8 `json:",omitempty"`
+}
+
+// countryChoice is the outcome of bestCountry, for the debug info.
+type countryChoice struct {
+	Chosen     string
+	Candidates []countryVote
+}
+
+// rankedCityDBs returns the city databases in -country_priority order.
+func rankedCityDBs() []*database {
+	dbs := cityDBs()
+	ranked := make([]*database, 0, len(dbs))
+	for _, name := range countryPriority {
+		for i, db := range dbs {
+			if db != nil && db.name == name {
+				ranked, dbs[i] = append(ranked, db), nil
+			}
+		}
+	}
+	for _, db := range dbs {
+		if db != nil {
+			ranked = append(ranked, db)
+		}
+	}
+	return ranked
+}
+
+// bestCountry replaces loc, when the city databases disagree on the country of
+// ip, by the location of the country with the highest combined confidence: the
+// sum of those of the databases having it. Ties, including between databases
+// without confidence data, are won by the highest ranked database, which the
+// location is then taken from. The votes are recorded when debugging.
+func (o options) bestCountry(ip string, loc *location) {
+	if *countryResolution != "confidence" {
+		return
+	}
+	ipaddr := net.ParseIP(ip)
+	var votes []countryVote
+	locations := make(map[string]location) // of the highest ranked database of each country
+	providers := make(map[string]*database)
+	scores := make(map[string]int)
+	var best string
+	for _, db := range rankedCityDBs() {
+		other, err := lookupLocation(db, ip, o)
+		if err != nil || other.CountryCode == "" {
+			continue
+		}
+		vote := countryVote{Database: db.name, Country: other.CountryCode}
+		if r := o.reader(db); r != nil && strings.Contains(r.Metadata().DatabaseType, "Enterprise") {
+			if record, err := r.Enterprise(ipaddr); err == nil {
+				vote.Confidence = record.Country.Confidence
+			}
+		}
+		votes = append(votes, vote)
+		if _, ok := locations[vote.Country]; !ok {
+			locations[vote.Country], providers[vote.Country] = other, db
+		}
+		scores[vote.Country] += int(vote.Confidence)
+		// Votes come in ranking order, so earlier countries win ties
+		if best == "" || scores[vote.Country] > scores[best] {
+			best = vote.Country
+		}
+	}
+	if best == "" {
+		return
+	}
+	if best != loc.CountryCode {
+		*loc = locations[best]
+		o.provide("Location", providers[best])
+	}
+	if o.choice != nil {
+		o.debugMu.Lock()
+		*o.choice = countryChoice{Chosen: best, Candidates: votes}
+		o.debugMu.Unlock()
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+	"golang.org/x/sync/singleflight"
+)
+
+// databases lists every configured database, in registration order.
+var databases []*database
+
+// ErrDatabaseClosed is returned by lookups which hit a reader closed by a
+// concurrent reload; the request can safely be retried.
+var ErrDatabaseClosed = errors.New("database closed while in use, please retry")
+
+// errNotLoaded is returned by lookups on a database whose file was missing at
+// startup and which hasn't been loaded since.
+var errNotLoaded = errors.New("database not loaded")
+
+// awaitInterval is how often missing database files are looked for, when
+// waiting for them to appear.
+const awaitInterval = time.Second
+
+// probeIP is looked up by self-checks to make sure a reader is still usable.
+var probeIP = net.ParseIP("1.1.1.1")
+
+// database is a reloadable mmdb reader along with the file it is loaded from.
+type database struct {
+	name    string
+	file    *string
+	setting string // flag or environment variable the file is set with
+	probe   func(*geoip2.Reader) error
+
+	failures int32        // consecutive failed self-checks
+	current  atomic.Value // *dbReader, swapped as a whole on reload
+
+	loading sync.Mutex         // serializes loads
+	closed  bool               // on shutdown, guarded by loading
+	reloads singleflight.Group // shares the outcome of concurrent reloads
+}
+
+// dbReader is a loaded generation of a database. The file is also opened
+// with maxminddb directly for the lower-level APIs geoip2 does not expose.
+type dbReader struct {
+	*geoip2.Reader
+	mmdb     *maxminddb.Reader
+	loadedAt time.Time
+	warmup   *warmup // with -warmup_file or -warmup_ips
+
+	mu      sync.Mutex // guards the fields below
+	pins    int        // by requests using the reader throughout, see pinReaders
+	retired bool       // replaced by a reload, to be closed once unpinned
+	closed  bool
+}
+
+// retire closes the reader, as soon as it isn't pinned anymore.
+func (r *dbReader) retire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retired = true
+	if r.pins == 0 && !r.closed {
+		r.closed = true
+		r.Close()
+	}
+}
+
+// pin keeps the reader open until unpinned, unless it is closed already.
+func (r *dbReader) pin() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.pins++
+	return true
+}
+
+func (r *dbReader) unpin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pins--; r.pins == 0 && r.retired && !r.closed {
+		r.closed = true
+		r.Close()
+	}
+}
+
+// readerSet is the readers of the databases pinned by a request.
+type readerSet map[*database]*dbReader
+
+// pinReaders pins the current reader of every database, for the lookups of
+// a request to all use the same ones even if databases are reloaded midway:
+// replaced readers are kept open until released, past the drain period.
+func pinReaders() (readerSet, func()) {
+	set := make(readerSet, len(databases))
+	for _, db := range databases {
+		if r := db.pinnedReader(); r != nil {
+			set[db] = r
+		}
+	}
+	return set, func() {
+		for _, r := range set {
+			r.unpin()
+		}
+	}
+}
+
+// pinnedReader pins the current reader of db, which is nil if not loaded; it
+// must be unpinned once done with.
+func (db *database) pinnedReader() *dbReader {
+	// A reader retired and closed in between is replaced by then
+	for r := db.reader(); r != nil; r = db.reader() {
+		if r.pin() {
+			return r
+		}
+	}
+	return nil
+}
+
+// reader returns the reader of db pinned by the request, if any, or else its
+// current one.
+func (o options) reader(db *database) *dbReader {
+	if r, ok := o.readers[db]; ok {
+		return r
+	}
+	return db.reader()
+}
+
+func (r *dbReader) Close() error {
+	r.mmdb.Close()
+	return r.Reader.Close()
+}
+
+// buildDate returns the date the database was built, as its metadata tells.
+func (r *dbReader) buildDate() time.Time {
+	return time.Unix(int64(r.Metadata().BuildEpoch), 0).UTC()
+}
+
+// network returns the CIDR block of the database ip belongs to.
+func (r *dbReader) network(ip net.IP) string {
+	var none struct{}
+	if network, _, err := r.mmdb.LookupNetwork(ip, &none); err == nil && network != nil {
+		return network.String()
+	}
+	return ""
+}
+
+// isAnycast reports whether ip belongs to an anycast network, for databases
+// providing the trait (which geoip2 doesn't decode).
+func (r *dbReader) isAnycast(ip net.IP) bool {
+	var record struct {
+		Traits struct {
+			IsAnycast bool `maxminddb:"is_anycast"`
+		} `maxminddb:"traits"`
+	}
+	return r.mmdb.Lookup(ip, &record) == nil && record.Traits.IsAnycast
+}
+
+// asn reads the AS of ip, which combined Enterprise databases store among
+// their traits.
+func (r *dbReader) asn(ip net.IP) (*geoip2.ASN, error) {
+	if !strings.Contains(r.Metadata().DatabaseType, "Enterprise") {
+		return r.ASN(ip)
+	}
+	data, err := r.Enterprise(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &geoip2.ASN{
+		AutonomousSystemNumber:       data.Traits.AutonomousSystemNumber,
+		AutonomousSystemOrganization: data.Traits.AutonomousSystemOrganization,
+	}, nil
+}
+
+// asCountry returns the country code of the AS of ip, for ASN databases
+// providing it (which geoip2 doesn't decode).
+func (r *dbReader) asCountry(ip net.IP) string {
+	var record struct {
+		CountryCode string `maxminddb:"country_code"`
+	}
+	if r.mmdb.Lookup(ip, &record) != nil {
+		return ""
+	}
+	return strings.ToUpper(record.CountryCode)
+}
+
+// checkFamily returns errUnsupportedFamily for IPv6 addresses when the
+// database only has IPv4 data, as its metadata tells. IPv4-mapped addresses
+// are IPv6 ones without -normalize_v4_mapped, see normalizeIP.
+func (r *dbReader) checkFamily(ip string) error {
+	if r.Metadata().IPVersion == 4 && (net.ParseIP(ip).To4() == nil || strings.Contains(ip, ":")) {
+		return errUnsupportedFamily
+	}
+	return nil
+}
+
+// contains reports whether the database has data for ip; geoip2 returns empty
+// records rather than an error for the addresses it doesn't cover.
+func (r *dbReader) contains(ip net.IP) bool {
+	var none struct{}
+	_, ok, err := r.mmdb.LookupNetwork(ip, &none)
+	return err == nil && ok
+}
+
+type dbInfo struct {
+	Name string
+	source
+	LoadedAt time.Time
+	Degraded bool
+	Warmup   *warmup `json:",omitempty"`
+}
+
+func newDatabase(name string, file *string, setting string, probe func(*geoip2.Reader) error) *database {
+	db := &database{name: name, file: file, setting: setting, probe: probe}
+	databases = append(databases, db)
+	return db
+}
+
+// read runs f with the current (or pinned) reader, which it returns. Reads can fail
+// transiently while files are swapped, so failed ones are retried with the
+// then current reader, up to -read_retries times. Lookups of IPs without data
+// don't fail, and aren't retried, nor are the ones unsupported by the type of
+// database.
+func (db *database) read(o options, ip string, f func(*dbReader) error) (*dbReader, error) {
+	for attempt := 1; ; attempt++ {
+		r := o.reader(db)
+		if r == nil {
+			return nil, errNotLoaded
+		}
+		if err := r.checkFamily(ip); err != nil {
+			return r, err
+		}
+		err := f(r)
+		var invalid geoip2.InvalidMethodError
+		if err == nil || attempt > *readRetries || errors.As(err, &invalid) {
+			return r, readerError(err)
+		}
+		log.Printf("%s database read of %s failed, retrying (%d/%d): %v", db.name, ip, attempt, *readRetries, err)
+	}
+}
+
+// databaseNamed returns the configured database of the given name, if any.
+func databaseNamed(name string) *database {
+	for _, db := range databases {
+		if db.name == name {
+			return db
+		}
+	}
+	return nil
+}
+
+// reader returns the current reader, which is nil until a first successful load.
+func (db *database) reader() *dbReader {
+	r, _ := db.current.Load().(*dbReader)
+	return r
+}
+
+// load fully opens the configured file before swapping it in place of the
+// current reader, which is kept as-is if the new one cannot be opened. Lookups
+// never wait on a reload: the replaced reader is only closed after the
+// configured drain period, letting in-flight lookups complete, or once no
+// request pins it anymore.
+func (db *database) load() error {
+	db.loading.Lock()
+	defer db.loading.Unlock()
+	if db.closed {
+		return ErrDatabaseClosed
+	}
+
+	newReader, err := openReader(*db.file)
+	if err != nil {
+		return err
+	}
+	db.warm(newReader)
+
+	oldReader := db.reader()
+	db.current.Store(newReader)
+	if oldReader != nil {
+		time.AfterFunc(*reloadDrain, oldReader.retire)
+		if resultCache != nil {
+			resultCache.invalidate(context.Background())
+		}
+	}
+	return nil
+}
+
+// openReader fully opens file, both with geoip2 and maxminddb.
+func openReader(file string) (*dbReader, error) {
+	reader, err := geoip2.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	mmdb, err := maxminddb.Open(file)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &dbReader{Reader: reader, mmdb: mmdb, loadedAt: time.Now().UTC()}, nil
+}
+
+// reload loads the database again; reloads requested while one is already in
+// progress wait for it and share its outcome, rather than reading the file
+// once more.
+func (db *database) reload() error {
+	_, err, _ := db.reloads.Do("reload", func() (interface{}, error) {
+		return nil, db.load()
+	})
+	return err
+}
+
+// loadDatabases loads every database, handling missing files as configured:
+// "fail" exits, telling how to set the file, "warn" leaves the database unloaded (degraded) until it is
+// reloaded, and "wait" polls for the file in the background.
+func loadDatabases(missing string) {
+	for _, db := range databases {
+		err := db.load()
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			panic(err)
+		}
+		if missing == "fail" {
+			log.Fatalf("%s database file %s not found: set its path with %s, or start without it using -db_missing=warn or wait (IPINFO_DB_MISSING)", db.name, *db.file, db.setting)
+		}
+		log.Printf("%s database not loaded: %v", db.name, err)
+		if missing == "wait" {
+			go db.await(awaitInterval)
+		}
+	}
+}
+
+// await loads the database as soon as its file appears.
+func (db *database) await(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := db.load(); err == nil {
+			log.Printf("%s database loaded from %s", db.name, *db.file)
+			return
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("%s database failed to load: %v", db.name, err)
+		}
+	}
+}
+
+// source describes the currently loaded file, for attributing response data.
+func (db *database) source() source {
+	r := db.reader()
+	if r == nil {
+		return source{File: *db.file}
+	}
+	return readerSource(*db.file, r.Reader)
+}
+
+func (db *database) info() dbInfo {
+	r := db.reader()
+	if r == nil {
+		return dbInfo{Name: db.name, source: source{File: *db.file}, Degraded: true}
+	}
+	return dbInfo{
+		Name:     db.name,
+		source:   readerSource(*db.file, r.Reader),
+		LoadedAt: r.loadedAt,
+		Degraded: atomic.LoadInt32(&db.failures) > 0,
+		Warmup:   r.warmup,
+	}
+}
+
+// ready returns why the database can't serve lookups, if it can't: it must
+// be loaded and its reader must pass the probe.
+func (db *database) ready() error {
+	r := db.reader()
+	if r == nil {
+		return errNotLoaded
+	}
+	return readerError(db.probe(r.Reader))
+}
+
+func readerSource(file string, reader *geoip2.Reader) source {
+	md := reader.Metadata()
+	return source{
+		File:      file,
+		Type:      md.DatabaseType,
+		BuildDate: time.Unix(int64(md.BuildEpoch), 0).UTC(),
+	}
+}
+
+func databasesInfo() []dbInfo {
+	infos := make([]dbInfo, 0, len(databases))
+	for _, db := range databases {
+		infos = append(infos, db.info())
+	}
+	return infos
+}
+
+// readerError identifies lookups on closed readers, which maxminddb only
+// reports with an opaque error message.
+func readerError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "on a closed database") {
+		return ErrDatabaseClosed
+	}
+	return err
+}
+
+// selfCheck probes every database periodically, reloading the ones which
+// failed maxFailures times in a row from their configured file.
+func selfCheck(interval time.Duration, maxFailures int) {
+	for range time.Tick(interval) {
+		for _, db := range databases {
+			db.selfCheck(maxFailures)
+		}
+	}
+}
+
+func (db *database) selfCheck(maxFailures int) {
+	r := db.reader()
+	if r == nil {
+		// Not loaded yet, nothing to recover
+		return
+	}
+	err := db.probe(r.Reader)
+	if err == nil {
+		atomic.StoreInt32(&db.failures, 0)
+		return
+	}
+
+	failures := atomic.AddInt32(&db.failures, 1)
+	log.Printf("%s database self-check failed (%d/%d): %v", db.name, failures, maxFailures, err)
+	if int(failures) < maxFailures {
+		return
+	}
+	if err := db.reload(); err != nil {
+		log.Printf("%s database recovery failed: %v", db.name, err)
+		return
+	}
+	atomic.StoreInt32(&db.failures, 0)
+	log.Printf("%s database recovered by reloading %s", db.name, *db.file)
+}
+
+func reloadHandler(db *database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := db.reload(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   err.Error(),
+				"message": "failed to load database; using previous one...",
+			})
+			return
+		}
+		info := db.info()
+		c.JSON(http.StatusOK, gin.H{
+			"message":     fmt.Sprintf("%s database reloaded successfully", db.name),
+			"build_date":  info.BuildDate,
+			"reloaded_at": info.LoadedAt,
+		})
+	}
+}
+
+// reloadAllHandler reloads every configured database, reporting the outcome
+// for each of them; the ones which fail to load keep their previous reader.
+func reloadAllHandler(c *gin.Context) {
+	status, results := http.StatusOK, make(map[string]gin.H, len(databases))
+	for _, db := range databases {
+		if err := db.reload(); err != nil {
+			status = http.StatusInternalServerError
+			results[db.name] = gin.H{"reloaded": false, "error": err.Error()}
+			continue
+		}
+		info := db.info()
+		results[db.name] = gin.H{
+			"reloaded":    true,
+			"build_date":  info.BuildDate,
+			"reloaded_at": info.LoadedAt,
+		}
+	}
+	c.JSON(status, gin.H{"databases": results})
+}
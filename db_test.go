@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// withReader makes r the current reader of db for the duration of the test.
+func withReader(t *testing.T, db *database, r *dbReader) {
+	current := db.reader()
+	db.current.Store(r)
+	t.Cleanup(func() { db.current.Store(current) })
+}
+
+func TestReaderErrorClosed(t *testing.T) {
+	r := closedReader(t, "testdata/asn.mmdb")
+	if _, err := r.ASN(net.ParseIP("8.8.8.8")); !errors.Is(readerError(err), ErrDatabaseClosed) {
+		t.Errorf("readerError(%v) = %v, want %v", err, readerError(err), ErrDatabaseClosed)
+	}
+	if err := readerError(errors.New("invalid mmdb")); errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("readerError of another error = %v, want it as is", err)
+	}
+}
+
+func TestLookupClosedReader(t *testing.T) {
+	withReader(t, asnDB, closedReader(t, "testdata/asn.mmdb"))
+	c, w := testContext("/asn/8.8.8.8", nil)
+	lookup(c, "asn", "8.8.8.8")
+	var body struct{ Code string }
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusServiceUnavailable || body.Code != "database_closed" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("lookup with a closed reader = %d %q (Retry-After %q), want %d database_closed with a Retry-After", w.Code, body.Code, w.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+}
+
+func TestPinRetire(t *testing.T) {
+	r, err := openReader("testdata/asn.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.pin() {
+		t.Fatal("pin of an open reader failed")
+	}
+	r.retire()
+	if _, err := r.ASN(net.ParseIP("8.8.8.8")); err != nil || r.closed {
+		t.Errorf("pinned retired reader closed (read error %v)", err)
+	}
+	r.unpin()
+	if !r.closed {
+		t.Error("retired reader not closed once unpinned")
+	}
+	if r.pin() {
+		t.Error("pin of a closed reader succeeded")
+	}
+}
+
+// retired waits for r to be retired by a reload.
+func retired(t *testing.T, r *dbReader) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		r.mu.Lock()
+		done := r.retired
+		r.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("reader not retired after the reload")
+}
+
+func TestPinnedReadersAcrossReload(t *testing.T) {
+	defer func(d time.Duration) { *reloadDrain = d }(*reloadDrain)
+	*reloadDrain = 0
+	db := withDatabase(t, &geoDB, "geoip", "testdata/city.mmdb")
+	defer func(dbs []*database) { databases = dbs }(databases)
+	databases = []*database{db}
+
+	// As bulk requests do, in -bulk_consistency=snapshot mode
+	o := testOptions()
+	var release func()
+	o.readers, release = pinReaders()
+	old := o.readers[db]
+	*db.file = "testdata/city-2024.mmdb"
+	if err := db.load(); err != nil {
+		t.Fatal(err)
+	}
+	retired(t, old)
+	for i := 0; i < 100; i++ {
+		if loc, err := getLocation("8.8.8.8", o); err != nil || loc.City.String() != "Mountain View" {
+			t.Fatalf("lookup %d after the reload = %q, %v; want the pinned generation's Mountain View", i, loc.City, err)
+		}
+	}
+	release()
+	if !old.closed {
+		t.Error("replaced reader not closed once released")
+	}
+	if loc, err := getLocation("8.8.8.8", testOptions()); err != nil || loc.City.String() != "Ashburn" {
+		t.Errorf("unpinned lookup = %q, %v; want the reloaded Ashburn", loc.City, err)
+	}
+}
+
+// BenchmarkLookupDuringReload compares lookups with and without reloads
+// swapping the reader meanwhile, every 10ms, the replaced ones staying open
+// for the -reload_drain period.
+func BenchmarkLookupDuringReload(b *testing.B) {
+	db := withDatabase(b, &asnDB, "asn", "testdata/asn.mmdb")
+	for _, reloading := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reloading=%t", reloading), func(b *testing.B) {
+			stop, done := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(done)
+				if !reloading {
+					return
+				}
+				tick := time.NewTicker(10 * time.Millisecond)
+				defer tick.Stop()
+				for {
+					select {
+					case <-stop:
+						return
+					case <-tick.C:
+					}
+					if err := db.load(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}()
+			o := testOptions()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := getAS("8.8.8.8", o); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			close(stop)
+			<-done
+		})
+	}
+}
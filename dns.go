@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// errInvalidHostname is returned for forward lookups of malformed hostnames,
+// which aren't sent to the resolver.
+var errInvalidHostname = errors.New("invalid hostname")
+
+// hostnameLabel matches a DNS label: letters, digits, hyphens (except at
+// either end) and, as found in the wild, underscores.
+var hostnameLabel = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?$`)
+
+// normalizeHostname lowercases host and strips its trailing dot, if any, then
+// makes sure it is a well-formed hostname.
+func normalizeHostname(host string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if name == "" || len(name) > maxHostLength {
+		return "", fmt.Errorf("%w %q", errInvalidHostname, host)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return "", fmt.Errorf("%w %q", errInvalidHostname, host)
+		}
+	}
+	return name, nil
+}
+
+// errInvalidTarget is returned for /resolve inputs which are neither a URL
+// nor an email address.
+var errInvalidTarget = errors.New("expecting a URL with a host or an email address")
+
+// extractHost returns the host of a URL (with a scheme, e.g. https://host/path)
+// or the domain of an email address (optionally with a display name, e.g.
+// Abuse <abuse@example.com>, or a mailto: URL).
+func extractHost(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if u, err := url.Parse(target); err == nil && u.Scheme == "mailto" {
+		target = u.Opaque
+	} else if err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Hostname(), nil
+	}
+	addr, err := mail.ParseAddress(target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", errInvalidTarget, target)
+	}
+	return addr.Address[strings.LastIndex(addr.Address, "@")+1:], nil
+}
+
+// resolver is used for every DNS lookup, with the configured server if any.
+var resolver = net.DefaultResolver
+
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+func lookupPTR(ctx context.Context, ip string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, *dnsTimeout)
+	defer cancel()
+	return resolver.LookupAddr(ctx, ip)
+}
+
+func lookupHost(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, *dnsTimeout)
+	defer cancel()
+	return resolver.LookupHost(ctx, host)
+}
+
+// dnsErrorStatus maps DNS errors to a status code: 504 for timeouts, 404 for
+// unknown names and 502 for anything else.
+func dnsErrorStatus(err error) (int, string, bool) {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "dns_timeout", true
+	case !errors.As(err, &dnsErr):
+		return 0, "", false
+	case dnsErr.IsTimeout:
+		return http.StatusGatewayTimeout, "dns_timeout", true
+	case dnsErr.IsNotFound:
+		return http.StatusNotFound, "not_found", true
+	default:
+		return http.StatusBadGateway, "dns_error", true
+	}
+}
+
+// reverse is the result of a reverse DNS lookup.
+type reverse struct {
+	IP        net.IP
+	Hostnames []string
+}
+
+// forward is the result of a forward DNS lookup, with every resolved IP up
+// to the configured maximum.
+type forward struct {
+	Host      string
+	IPs       []resolvedIP
+	Truncated bool `json:",omitempty"` // more IPs were resolved
+}
+
+// resolvedIP is the lookup of one of the IPs of a host or, as in bulk
+// lookups, the error it failed with (e.g. for private IPs, or those the
+// databases don't list).
+type resolvedIP struct {
+	ipinfo
+	Error string `json:",omitempty"`
+}
+
+func getReverse(ctx context.Context, ip string) (reverse, error) {
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return reverse{}, invalidIP(ip)
+	}
+	ptrs, err := lookupPTR(ctx, ip)
+	return reverse{IP: ipaddr, Hostnames: ptrs}, err
+}
+
+func getForward(ctx context.Context, host string, o options) (forward, error) {
+	host, err := normalizeHostname(host)
+	if err != nil {
+		return forward{}, err
+	}
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		return forward{}, err
+	}
+	fwd := forward{Host: host}
+	if *maxResolved > 0 && len(ips) > *maxResolved {
+		ips, fwd.Truncated = ips[:*maxResolved], true
+	}
+	// The host only fails to be looked up when none of its IPs could be
+	fwd.IPs = make([]resolvedIP, 0, len(ips))
+	var firstErr error
+	found := false
+	for _, ip := range ips {
+		info, err := getIPInfo(ip, o)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			fwd.IPs = append(fwd.IPs, resolvedIP{ipinfo: ipinfo{IP: net.ParseIP(ip)}, Error: err.Error()})
+			continue
+		}
+		found = true
+		fwd.IPs = append(fwd.IPs, resolvedIP{ipinfo: info})
+	}
+	if !found && firstErr != nil {
+		return forward{}, firstErr
+	}
+	return fwd, nil
+}
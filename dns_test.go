@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// withHostIPs makes the resolver answer every A query with ips, and AAAA ones
+// with none, for the duration of the test.
+func withHostIPs(t *testing.T, ips ...string) {
+	previous := resolver
+	t.Cleanup(func() { resolver = previous })
+	resolver = &net.Resolver{PreferGo: true, Dial: func(context.Context, string, string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go answerDNS(server, ips)
+		return client, nil
+	}}
+}
+
+// answerDNS answers a DNS query over conn, which is a stream (with the
+// length-prefixed messages of DNS over TCP) to the resolver.
+func answerDNS(conn net.Conn, ips []string) {
+	defer conn.Close()
+	var size uint16
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return
+	}
+	query := make([]byte, size)
+	if _, err := io.ReadFull(conn, query); err != nil {
+		return
+	}
+	// The question follows the 12-byte header: a name, its type and class
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return
+	}
+	msg := append([]byte(nil), query[:end]...)
+	binary.BigEndian.PutUint16(msg[2:], 0x8180) // a recursive response, without error
+	binary.BigEndian.PutUint16(msg[10:], 0)     // without the additional records of the query
+	var answers uint16
+	if qtype := binary.BigEndian.Uint16(query[end-4:]); qtype == 1 {
+		for _, ip := range ips {
+			msg = append(msg, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4) // the question's name, A, IN, TTL 60
+			msg = append(msg, net.ParseIP(ip).To4()...)
+			answers++
+		}
+	}
+	binary.BigEndian.PutUint16(msg[6:], answers)
+	binary.Write(conn, binary.BigEndian, uint16(len(msg)))
+	conn.Write(msg)
+}
+
+func TestGetForwardPartial(t *testing.T) {
+	withHostIPs(t, "8.8.8.8", "10.0.0.1")
+	fwd, err := getForward(context.Background(), "dns.example", testOptions())
+	if err != nil {
+		t.Fatalf("getForward of a host with a listed IP error = %v, want none", err)
+	}
+	if len(fwd.IPs) != 2 {
+		t.Fatalf("getForward = %d IPs, want 2", len(fwd.IPs))
+	}
+	for _, ip := range fwd.IPs {
+		switch ip.IP.String() {
+		case "8.8.8.8":
+			if ip.Error != "" || ip.AS == nil || ip.AS.Number != 15169 {
+				t.Errorf("8.8.8.8 = AS %v, error %q; want AS15169", ip.AS, ip.Error)
+			}
+		case "10.0.0.1":
+			if ip.Error == "" || ip.AS != nil || ip.Location != nil {
+				t.Errorf("10.0.0.1 = AS %v, location %v, error %q; want an error only", ip.AS, ip.Location, ip.Error)
+			}
+		default:
+			t.Errorf("unexpected IP %s", ip.IP)
+		}
+	}
+
+	// Hosts fail when none of their IPs could be looked up
+	withHostIPs(t, "10.0.0.1", "192.168.0.1")
+	if _, err := getForward(context.Background(), "dns.example", testOptions()); !errors.Is(err, errNotFound) {
+		t.Errorf("getForward of a host without listed IPs error = %v, want %v", err, errNotFound)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// jsonEncoder is the part of json.Encoder responses are written with.
+type jsonEncoder interface {
+	Encode(v interface{}) error
+	SetIndent(prefix, indent string)
+}
+
+// jsoniterAPI behaves as encoding/json does, MarshalJSON methods and HTML
+// escaping included, with fewer allocations.
+var jsoniterAPI = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// newJSONEncoder returns an encoder writing to w with the -json_encoder.
+func newJSONEncoder(w io.Writer) jsonEncoder {
+	if *jsonEncoding == "jsoniter" {
+		return jsoniterAPI.NewEncoder(w)
+	}
+	return json.NewEncoder(w)
+}
+
+// marshalJSON encodes v with the -json_encoder, for the MarshalJSON methods of
+// the response types to use the same encoder as the responses embedding them.
+func marshalJSON(v interface{}) ([]byte, error) {
+	if *jsonEncoding == "jsoniter" {
+		return jsoniterAPI.Marshal(v)
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// encoded returns v as encoded with the given -json_encoder.
+func encoded(t testing.TB, encoder string, v interface{}) []byte {
+	defer func(e string) { *jsonEncoding = e }(*jsonEncoding)
+	*jsonEncoding = encoder
+	var buf bytes.Buffer
+	if err := newJSONEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testResults(t testing.TB) []interface{} {
+	var results []interface{}
+	o := testOptions()
+	o.network = true
+	multi := testOptions()
+	multi.langs = []string{"en", "de", "ja"}
+	for _, o := range []options{o, multi} {
+		for _, ip := range []string{"8.8.8.8", "6.6.6.6", "1.1.1.1", "2001:4860:4860::8888"} {
+			info, err := getIPInfo(ip, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, info, toFlat(info), toVersion(info, schemaV1))
+		}
+	}
+	return results
+}
+
+func TestJSONEncodersAgree(t *testing.T) {
+	for _, v := range testResults(t) {
+		if std, iter := encoded(t, "stdlib", v), encoded(t, "jsoniter", v); !bytes.Equal(std, iter) {
+			t.Errorf("jsoniter encoding differs:\n%s\nwant:\n%s", iter, std)
+		}
+	}
+}
+
+func BenchmarkJSONEncoders(b *testing.B) {
+	results := testResults(b)
+	for _, encoder := range []string{"stdlib", "jsoniter"} {
+		b.Run(encoder, func(b *testing.B) {
+			defer func(e string) { *jsonEncoding = e }(*jsonEncoding)
+			*jsonEncoding = encoder
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := newJSONEncoder(&buf).Encode(results[i%len(results)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// explanation tells, with ?explain=true, where each field of an ipinfo comes
+// from. Cached is whether its result is cached, which it would have been served
+// from without ?explain (explained results are always looked up).
+type explanation struct {
+	Cached bool
+	Fields map[string]fieldSource // keyed by field, e.g. AS or Location
+}
+
+// fieldSource is where a field of an ipinfo comes from: the database it was
+// read from and the network the IP matched in it, the override applied to it
+// if any, and the names which fell back to another language than requested,
+// keyed by "Name.lang" and mapped to the language used ("" for the
+// -unknown_placeholder).
+type fieldSource struct {
+	Database  string
+	Network   string            `json:",omitempty"`
+	Override  string            `json:",omitempty"` // network of the applied override
+	Fallbacks map[string]string `json:",omitempty"`
+}
+
+// explainAllowed reports whether the client of c may get explanations, which
+// expose how the server is configured: with the reload token, or in debug
+// mode when none is configured.
+func explainAllowed(c *gin.Context) bool {
+	if *reloadToken == "" {
+		return gin.IsDebugging()
+	}
+	return subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(*reloadToken)) == 1
+}
+
+// explain describes the sources of the fields of info, the result of getIPInfo
+// called with o, whose providers it relies on.
+func explain(info ipinfo, o options, cached bool) *explanation {
+	e := &explanation{Cached: cached, Fields: make(map[string]fieldSource)}
+	o.debugMu.Lock()
+	providers := make(map[string]string, len(o.providers))
+	for field, name := range o.providers {
+		providers[field] = name
+	}
+	o.debugMu.Unlock()
+
+	if info.AS != nil {
+		e.Fields["AS"] = o.fieldSource(info.IP, databaseNamed(providers["AS"]), info.AS.Overridden, func(ov override) bool { return ov.AS != nil })
+	}
+	if loc := info.Location; loc != nil {
+		db := databaseNamed(providers["Location"])
+		src := o.fieldSource(info.IP, db, loc.Overridden, func(ov override) bool { return ov.Location != nil })
+		if db != nil {
+			if r := o.reader(db); r != nil {
+				if geo, err := r.City(info.IP); err == nil {
+					src.Fallbacks = o.fallbacks(geo)
+				}
+			}
+		}
+		e.Fields["Location"] = src
+	}
+	optional := []struct {
+		field   string
+		present bool
+		db      *database
+	}{
+		{"ISP", info.ISP != nil, ispDB},
+		{"Anonymity", info.Anonymity != nil, anonDB},
+		{"Traits", info.Traits != nil, traitsDB()},
+		{"Domain", info.Domain != "", domainDB},
+	}
+	for _, f := range optional {
+		if f.present && f.db != nil {
+			e.Fields[f.field] = o.fieldSource(info.IP, f.db, false, nil)
+		}
+	}
+	return e
+}
+
+// fieldSource returns the source of a field read from db, and patched by the
+// first override of ip matching when overridden.
+func (o options) fieldSource(ip net.IP, db *database, overridden bool, matches func(override) bool) fieldSource {
+	var src fieldSource
+	if db != nil {
+		src.Database = db.name
+		if r := o.reader(db); r != nil && r.contains(ip) {
+			src.Network = r.network(ip)
+		}
+	}
+	if overridden {
+		for _, ov := range overrides {
+			if ov.ipNet.Contains(ip) && matches(ov) {
+				src.Override = ov.Network
+				break
+			}
+		}
+	}
+	return src
+}
+
+// fallbacks lists the names of geo which aren't in the requested languages.
+func (o options) fallbacks(geo *geoip2.City) map[string]string {
+	names := map[string]map[string]string{
+		"Continent": geo.Continent.Names,
+		"Country":   geo.Country.Names,
+		"City":      geo.City.Names,
+	}
+	var fallbacks map[string]string
+	for field, n := range names {
+		for _, lang := range o.langs {
+			if used := nameLanguage(n, lang); used != lang {
+				if fallbacks == nil {
+					fallbacks = make(map[string]string)
+				}
+				fallbacks[field+"."+lang] = used
+			}
+		}
+	}
+	return fallbacks
+}
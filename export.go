@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// exportFlushEvery is how many networks are written between flushes of the
+// export stream; writes block on slow clients, so the networks are only read
+// as fast as they are consumed.
+const exportFlushEvery = 1000
+
+// exportHandler streams the networks of the database named by ?db= (asn by
+// default) as NDJSON, one {network, ...fields} record per line, with the
+// fields as stored in the database. Networks can be filtered by ?country= and
+// ?asn= (both comma-separated), and their number capped with ?limit=. The
+// stream is cut off past -max_response_size, see limitedWriter.
+func exportHandler(c *gin.Context) {
+	name := c.DefaultQuery("db", "asn")
+	db := databaseNamed(name)
+	if db == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown database %q", name), "code": "not_found"})
+		return
+	}
+	// Pinned for the reader not to be closed by a reload while streaming
+	r := db.pinnedReader()
+	if r == nil {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errNotLoaded.Error(), "code": "database_not_loaded"})
+		return
+	}
+	defer r.unpin()
+	limit := -1
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit %q", l), "code": "bad_request"})
+			return
+		}
+	}
+	countries := make(map[string]bool)
+	for _, cc := range splitList(c.Query("country")) {
+		countries[strings.ToUpper(cc)] = true
+	}
+	asns := make(map[uint64]bool)
+	for _, a := range splitList(c.Query("asn")) {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(a), "AS"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid asn %q", a), "code": "bad_request"})
+			return
+		}
+		asns[n] = true
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", mimeNDJSON)
+	enc := newJSONEncoder(c.Writer)
+	networks := r.mmdb.Networks(maxminddb.SkipAliasedNetworks)
+	for n := 0; n != limit && networks.Next(); {
+		var record map[string]interface{}
+		network, err := networks.Network(&record)
+		if err != nil {
+			enc.Encode(gin.H{"error": err.Error(), "code": "export_failed"})
+			return
+		}
+		if len(countries) > 0 && !countries[recordCountry(record)] {
+			continue
+		}
+		if len(asns) > 0 && !asns[recordASN(record)] {
+			continue
+		}
+		if record == nil {
+			record = make(map[string]interface{}, 1)
+		}
+		record["network"] = network.String()
+		if err := enc.Encode(record); err != nil {
+			return // the client went away
+		}
+		if n++; n%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := networks.Err(); err != nil {
+		enc.Encode(gin.H{"error": err.Error(), "code": "export_failed"})
+	}
+}
+
+// rawRecord is the record of an IP as stored in a database, with every key.
+type rawRecord struct {
+	Network string                 `json:",omitempty"`
+	Record  map[string]interface{} `json:",omitempty"`
+	Error   string                 `json:",omitempty"`
+}
+
+// recordHandler returns the raw records of :ip in the database named by ?db=,
+// or in every loaded database, keyed by database name; to tell fields absent
+// from a database apart from the ones dropped by our own mapping.
+func recordHandler(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid ip address %q", c.Param("ip")), "code": "bad_request"})
+		return
+	}
+	dbs := databases
+	if name := c.Query("db"); name != "" {
+		db := databaseNamed(name)
+		if db == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown database %q", name), "code": "not_found"})
+			return
+		}
+		dbs = []*database{db}
+	}
+	records := make(map[string]rawRecord, len(dbs))
+	for _, db := range dbs {
+		r := db.pinnedReader()
+		records[db.name] = lookupRecord(r, ip)
+		if r != nil {
+			r.unpin()
+		}
+	}
+	writeJSON(c, requestOptions(c), http.StatusOK, records)
+}
+
+// lookupRecord returns the raw record of ip read by r, which may be nil.
+func lookupRecord(r *dbReader, ip net.IP) rawRecord {
+	if r == nil {
+		return rawRecord{Error: errNotLoaded.Error()}
+	}
+	var rec rawRecord
+	network, ok, err := r.mmdb.LookupNetwork(ip, &rec.Record)
+	switch {
+	case err != nil:
+		rec.Error = readerError(err).Error()
+	case !ok:
+		rec.Error = errNotFound.Error()
+	default:
+		rec.Network = network.String()
+	}
+	return rec
+}
+
+// recordCountry returns the ISO code of the country of a raw record, if any.
+func recordCountry(record map[string]interface{}) string {
+	country, _ := record["country"].(map[string]interface{})
+	code, _ := country["iso_code"].(string)
+	return strings.ToUpper(code)
+}
+
+// recordASN returns the AS number of a raw record, which combined Enterprise
+// databases store among their traits, or 0.
+func recordASN(record map[string]interface{}) uint64 {
+	if asn, ok := record["autonomous_system_number"].(uint64); ok {
+		return asn
+	}
+	traits, _ := record["traits"].(map[string]interface{})
+	asn, _ := traits["autonomous_system_number"].(uint64)
+	return asn
+}
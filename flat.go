@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// flatInfo is the ?flat=true form of ipinfo, with the fields of its AS and
+// location at the top level rather than nested. Those of the AS, but its
+// number and names (ASN, Org and NormalizedOrg), are prefixed with AS.
+type flatInfo struct {
+	IP                 net.IP
+	Hostnames          []string
+	ASN                uint       `json:",omitempty"`
+	Org                string     `json:",omitempty"`
+	NormalizedOrg      string     `json:",omitempty"`
+	ASPlain            string     `json:",omitempty"`
+	ASDot              string     `json:",omitempty"`
+	ASPrefixed         string     `json:",omitempty"`
+	ASCountryCode      string     `json:",omitempty"`
+	ASNetwork          string     `json:",omitempty"`
+	ASBuildDate        *time.Time `json:",omitempty"`
+	ASOverridden       bool       `json:",omitempty"`
+	ASIncomplete       bool       `json:",omitempty"`
+	Continent          localized
+	ContinentCode      string
+	Country            localized
+	CountryCode        string
+	CountryCode3       string `json:",omitempty"`
+	City               localized
+	Subdivisions       []subdivision
+	Formatted          localized           `json:",omitempty"`
+	RepresentedCountry *representedCountry `json:",omitempty"`
+	Latitude           *float64            `json:",omitempty"`
+	Longitude          *float64            `json:",omitempty"`
+	AccuracyRadius     uint16              `json:",omitempty"` // in km
+	Geohash            string              `json:",omitempty"`
+	TimeZone           string              `json:",omitempty"`
+	LocalTime          string              `json:",omitempty"`
+	Downgraded         bool                `json:",omitempty"`
+	Approximated       string              `json:",omitempty"`
+	Smoothed           string              `json:",omitempty"`
+	NearestCity        *nearestCity        `json:",omitempty"`
+	Unnamed            bool                `json:",omitempty"`
+	IsAnycast          bool                `json:",omitempty"`
+	Network            string              `json:",omitempty"` // of the location, with ?network=true
+	BuildDate          *time.Time          `json:",omitempty"` // of the location
+	Overridden         bool                `json:",omitempty"` // the location was patched by -overrides
+	ISP                *isp                `json:",omitempty"`
+	Anonymity          *anonymity          `json:",omitempty"`
+	Traits             *traits             `json:",omitempty"`
+	Domain             string              `json:",omitempty"`
+	AddressType        string              `json:",omitempty"`
+	Fingerprint        string              `json:",omitempty"`
+	Unsupported        []string            `json:",omitempty"`
+	Debug              *debugInfo          `json:",omitempty"`
+	Explain            *explanation        `json:",omitempty"`
+}
+
+// MarshalJSON renders missing coordinates as location does.
+func (f flatInfo) MarshalJSON() ([]byte, error) {
+	type plain flatInfo
+	if *missingCoords != "null" || f.Latitude != nil {
+		return marshalJSON(plain(f))
+	}
+	return marshalJSON(struct {
+		plain
+		Latitude, Longitude *float64
+	}{plain: plain(f)})
+}
+
+func toFlat(info ipinfo) flatInfo {
+	f := flatInfo{
+		IP:          info.IP,
+		Hostnames:   info.Hostnames,
+		ISP:         info.ISP,
+		Anonymity:   info.Anonymity,
+		Traits:      info.Traits,
+		Domain:      info.Domain,
+		AddressType: info.AddressType,
+		Fingerprint: info.Fingerprint,
+		Unsupported: info.Unsupported,
+		Debug:       info.Debug,
+		Explain:     info.Explain,
+	}
+	if asn := info.AS; asn != nil {
+		f.ASN, f.Org, f.NormalizedOrg = asn.Number, asn.Name, asn.Normalized
+		f.ASPlain, f.ASDot, f.ASPrefixed = asn.ASPlain, asn.ASDot, asn.Prefixed
+		f.ASCountryCode, f.ASNetwork, f.ASBuildDate = asn.CountryCode, asn.Network, asn.BuildDate
+		f.ASOverridden, f.ASIncomplete = asn.Overridden, asn.Incomplete
+	}
+	if loc := info.Location; loc != nil {
+		f.Continent, f.ContinentCode = loc.Continent, loc.ContinentCode
+		f.Country, f.CountryCode, f.CountryCode3 = loc.Country, loc.CountryCode, loc.CountryCode3
+		f.City, f.Subdivisions = loc.City, loc.Subdivisions
+		f.Formatted, f.RepresentedCountry = loc.Formatted, loc.RepresentedCountry
+		f.Latitude, f.Longitude, f.AccuracyRadius = loc.Latitude, loc.Longitude, loc.AccuracyRadius
+		f.Geohash, f.TimeZone, f.LocalTime = loc.Geohash, loc.TimeZone, loc.LocalTime
+		f.Downgraded, f.Approximated, f.Smoothed = loc.Downgraded, loc.Approximated, loc.Smoothed
+		f.NearestCity, f.Unnamed, f.IsAnycast = loc.NearestCity, loc.Unnamed, loc.IsAnycast
+		f.Network, f.BuildDate, f.Overridden = loc.Network, loc.BuildDate, loc.Overridden
+	}
+	return f
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fill sets every exported field of the struct v points to a non-zero value.
+func fill(t *testing.T, v interface{}) {
+	t.Helper()
+	s := reflect.ValueOf(v).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		if s.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		f := s.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(s.Type().Field(i).Name)
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Uint, reflect.Uint16:
+			f.SetUint(uint64(i + 1))
+		case reflect.Ptr:
+			f.Set(reflect.New(f.Type().Elem()))
+		case reflect.Map:
+			f.Set(reflect.MakeMap(f.Type()))
+			f.SetMapIndex(reflect.ValueOf("en"), reflect.ValueOf(s.Type().Field(i).Name))
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		default:
+			t.Fatalf("can't fill %s fields", f.Kind())
+		}
+	}
+}
+
+// TestFlatParity checks that every field of the nested AS and location has
+// its flat counterpart, as new ones must.
+func TestFlatParity(t *testing.T) {
+	var asn as
+	var loc location
+	fill(t, &asn)
+	fill(t, &loc)
+	f := reflect.ValueOf(toFlat(ipinfo{AS: &asn, Location: &loc}))
+	flatNames := map[string]string{"Number": "ASN", "Name": "Org", "Normalized": "NormalizedOrg", "ASPlain": "ASPlain", "ASDot": "ASDot"}
+	for _, nested := range []interface{}{asn, loc} {
+		v := reflect.ValueOf(nested)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if _, ok := nested.(as); ok {
+				if name = flatNames[field.Name]; name == "" {
+					name = "AS" + field.Name
+				}
+			}
+			if flat := f.FieldByName(name); !flat.IsValid() {
+				t.Errorf("%T.%s has no flat %s field", nested, field.Name, name)
+			} else if !reflect.DeepEqual(flat.Interface(), v.Field(i).Interface()) {
+				t.Errorf("flat %s = %v, want %T.%s %v", name, flat.Interface(), nested, field.Name, v.Field(i).Interface())
+			}
+		}
+	}
+	// As well as every other field of ipinfo
+	info := reflect.TypeOf(ipinfo{})
+	for i := 0; i < info.NumField(); i++ {
+		if name := info.Field(i).Name; name != "AS" && name != "Location" && !f.FieldByName(name).IsValid() {
+			t.Errorf("ipinfo.%s has no flat field", name)
+		}
+	}
+}
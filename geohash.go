@@ -0,0 +1,41 @@
+package main
+
+// geohashAlphabet is the base 32 alphabet of geohashes.
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// defaultGeohashLen is the length of geohashes requested with ?geohash=true,
+// a cell of about 150 by 150 m.
+const defaultGeohashLen = 7
+
+// maxGeohashLen is the length of the longest geohashes, finer than
+// coordinates are ever accurate to.
+const maxGeohashLen = 12
+
+// geohash encodes coordinates as a geohash of the given length: longitude
+// and latitude ranges are bisected in turn, each bit telling which half the
+// point is in, and every 5 bits make up a character.
+func geohash(lat, long float64, length int) string {
+	latRange, longRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	hash := make([]byte, 0, length)
+	even, bit, ch := true, 0, 0
+	for len(hash) < length {
+		r, v := &latRange, lat
+		if even {
+			r, v = &longRange, long
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
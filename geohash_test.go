@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestGeohashMissingCoords(t *testing.T) {
+	defer func(mode string) { *missingCoords = mode }(*missingCoords)
+	*missingCoords = "zero"
+	o := testOptions()
+	o.geohash, o.nearestCity = 7, true
+	// 9.9.9.9 has no coordinates, only the placeholder
+	loc, err := getLocation("9.9.9.9", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Latitude == nil || *loc.Latitude != 0 || loc.Longitude == nil || *loc.Longitude != 0 {
+		t.Errorf("coordinates with -missing_coords=zero = %v, %v; want 0, 0", loc.Latitude, loc.Longitude)
+	}
+	if loc.Geohash != "" || loc.NearestCity != nil {
+		t.Errorf("geohash %q, nearest city %v of placeholder coordinates; want none", loc.Geohash, loc.NearestCity)
+	}
+	if loc, err := getLocation("8.8.8.8", o); err != nil || len(loc.Geohash) != 7 {
+		t.Errorf("geohash of 8.8.8.8 = %q, %v; want 7 characters", loc.Geohash, err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// GeoJSON (RFC 7946) objects, see https://datatracker.ietf.org/doc/html/rfc7946
+type geoFeature struct {
+	Type       string      `json:"type"`
+	CRS        *geoCRS     `json:"crs,omitempty"`
+	Geometry   *geoPoint   `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoFeatureCollection struct {
+	Type     string       `json:"type"`
+	CRS      *geoCRS      `json:"crs,omitempty"`
+	Features []geoFeature `json:"features"`
+}
+
+// geoCRS is the named CRS member of the 2008 GeoJSON specification. RFC 7946
+// dropped it, as coordinates are always WGS84 (EPSG:4326), so it is only
+// included for other projections.
+type geoCRS struct {
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties"`
+}
+
+// Supported coordinate reference systems, for ?crs=
+const (
+	crsWGS84       = "EPSG:4326"
+	crsWebMercator = "EPSG:3857"
+)
+
+var errNoCoordinates = errors.New("no coordinates available for this ip address")
+
+// project converts WGS84 coordinates to [x, y] in the given CRS; for WGS84,
+// that's [longitude, latitude] as required by RFC 7946 (section 3.1.1).
+func project(lat, long float64, crs string) [2]float64 {
+	if crs == crsWebMercator {
+		const radius = 6378137.0 // of the WGS84 ellipsoid, in m
+		return [2]float64{
+			radius * long * math.Pi / 180,
+			radius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)),
+		}
+	}
+	return [2]float64{long, lat}
+}
+
+func newCRS(crs string) *geoCRS {
+	if crs == crsWGS84 {
+		return nil
+	}
+	return &geoCRS{Type: "name", Properties: map[string]string{
+		"name": "urn:ogc:def:crs:EPSG::" + strings.TrimPrefix(crs, "EPSG:"),
+	}}
+}
+
+// newFeature returns a Feature for the given location; its geometry is null
+// when coordinates are missing, including the 0,0 placeholders of
+// -missing_coords=zero (as in the databases, 0,0 is never a real location).
+// Beware that GeoJSON positions are in x, y order, i.e. longitude first.
+func newFeature(loc location, properties interface{}, crs string) geoFeature {
+	f := geoFeature{Type: "Feature", Properties: properties}
+	if loc.Latitude != nil && loc.Longitude != nil && (*loc.Latitude != 0 || *loc.Longitude != 0) {
+		f.Geometry = &geoPoint{
+			Type:        "Point",
+			Coordinates: project(*loc.Latitude, *loc.Longitude, crs),
+		}
+	}
+	return f
+}
+
+// toGeoJSON converts a lookup result to GeoJSON: single results become a
+// Feature (404 without coordinates), bulk results a FeatureCollection. Their
+// coordinates are projected in crs, which must be one of the supported ones.
+func toGeoJSON(obj interface{}, crs string) (interface{}, int, error) {
+	if crs != crsWGS84 && crs != crsWebMercator {
+		return nil, http.StatusBadRequest, fmt.Errorf("unsupported crs %q (available: %s, %s)", crs, crsWGS84, crsWebMercator)
+	}
+	switch v := obj.(type) {
+	case location:
+		return singleFeature(newFeature(v, v, crs), crs)
+	case ipinfo:
+		var loc location
+		if v.Location != nil {
+			loc = *v.Location
+		}
+		return singleFeature(newFeature(loc, v, crs), crs)
+	case []bulkEntry:
+		fc := geoFeatureCollection{Type: "FeatureCollection", CRS: newCRS(crs), Features: make([]geoFeature, 0, len(v))}
+		for _, entry := range v {
+			var loc location
+			if entry.Result != nil && entry.Result.Location != nil {
+				loc = *entry.Result.Location
+			}
+			fc.Features = append(fc.Features, newFeature(loc, entry, crs))
+		}
+		return fc, http.StatusOK, nil
+	default:
+		return nil, http.StatusBadRequest, errors.New("geojson format is only available for location data")
+	}
+}
+
+func singleFeature(f geoFeature, crs string) (interface{}, int, error) {
+	if f.Geometry == nil {
+		return nil, http.StatusNotFound, errNoCoordinates
+	}
+	f.CRS = newCRS(crs)
+	return f, http.StatusOK, nil
+}
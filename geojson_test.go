@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestGeoJSONMissingCoordinates(t *testing.T) {
+	zero := 0.0
+	lat, long := 37.386, -122.0838
+	tests := []struct {
+		name   string
+		loc    location
+		status int
+	}{
+		{"coordinates", location{Latitude: &lat, Longitude: &long}, http.StatusOK},
+		{"none", location{}, http.StatusNotFound},
+		{"zero placeholders", location{Latitude: &zero, Longitude: &zero}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if _, status, _ := toGeoJSON(tt.loc, crsWGS84); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestGeoJSONCoordinates(t *testing.T) {
+	lat, long := 37.386, -122.0838
+	loc := location{Latitude: &lat, Longitude: &long}
+	v, _, err := toGeoJSON(loc, crsWGS84)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := v.(geoFeature)
+	if f.Geometry.Coordinates != [2]float64{long, lat} {
+		t.Errorf("coordinates = %v, want [longitude, latitude] %v", f.Geometry.Coordinates, [2]float64{long, lat})
+	}
+	if f.CRS != nil {
+		t.Errorf("WGS84 feature CRS = %v, want none (RFC 7946)", f.CRS)
+	}
+
+	v, _, err = toGeoJSON(loc, crsWebMercator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = v.(geoFeature)
+	if x, y := f.Geometry.Coordinates[0], f.Geometry.Coordinates[1]; math.Abs(x-(-13590000)) > 10000 || math.Abs(y-4494000) > 10000 {
+		t.Errorf("web mercator coordinates = %v, want about [-13590000, 4494000]", f.Geometry.Coordinates)
+	}
+	if f.CRS == nil || f.CRS.Properties["name"] != "urn:ogc:def:crs:EPSG::3857" {
+		t.Errorf("web mercator feature CRS = %v, want EPSG::3857", f.CRS)
+	}
+
+	if _, status, _ := toGeoJSON(loc, "EPSG:1234"); status != http.StatusBadRequest {
+		t.Errorf("unsupported crs status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
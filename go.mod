@@ -4,5 +4,18 @@ go 1.16
 
 require (
 	github.com/gin-gonic/gin v1.7.2
-	github.com/oschwald/geoip2-golang v1.5.0 // indirect
+	github.com/go-redis/redis/v8 v8.11.0
+	github.com/json-iterator/go v1.1.11
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/oschwald/geoip2-golang v1.5.0
+	github.com/oschwald/maxminddb-golang v1.8.0
+	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/text v0.3.6
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
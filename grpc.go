@@ -0,0 +1,140 @@
+package main
+
+//go:generate protoc -I pb --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative ipinfo.proto
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/da-rod/ipinfo/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer exposes the lookups over gRPC, sharing the databases of the
+// REST API.
+type grpcServer struct {
+	pb.UnimplementedIPInfoServiceServer
+}
+
+// newGRPCServer returns the gRPC server of the lookups.
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterIPInfoServiceServer(s, grpcServer{})
+	return s
+}
+
+func grpcOptions(lang string) options {
+	o := defaultOptions()
+	if supportedLang(lang) {
+		o.lang, o.langs = lang, []string{lang}
+	}
+	return o
+}
+
+// grpcError maps lookup errors to the gRPC equivalent of their HTTP status.
+func grpcError(err error) error {
+	httpStatus, body := errorResponse(err)
+	code := codes.Internal
+	switch httpStatus {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, body["error"].(string))
+}
+
+func (grpcServer) Lookup(ctx context.Context, req *pb.LookupRequest) (*pb.IPInfo, error) {
+	if net.ParseIP(req.Ip) == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ip address %q", req.Ip)
+	}
+	info, err := getIPInfo(normalizeIP(req.Ip), grpcOptions(req.Lang))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbIPInfo(info), nil
+}
+
+func (grpcServer) LookupASN(ctx context.Context, req *pb.LookupRequest) (*pb.AS, error) {
+	if net.ParseIP(req.Ip) == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ip address %q", req.Ip)
+	}
+	asn, err := getAS(normalizeIP(req.Ip), grpcOptions(req.Lang))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbAS(asn), nil
+}
+
+func (grpcServer) LookupGeo(ctx context.Context, req *pb.LookupRequest) (*pb.Location, error) {
+	if net.ParseIP(req.Ip) == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ip address %q", req.Ip)
+	}
+	loc, err := getLocation(normalizeIP(req.Ip), grpcOptions(req.Lang))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbLocation(loc), nil
+}
+
+// LookupBatch reports errors per entry, like the /bulk endpoint.
+func (grpcServer) LookupBatch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	if len(req.Ips) > *maxBulk {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ip addresses can be looked up at once", *maxBulk)
+	}
+	o := grpcOptions(req.Lang)
+	o.hostnames = false
+	resp := &pb.BatchResponse{Entries: make([]*pb.BatchEntry, len(req.Ips))}
+	for i, ip := range req.Ips {
+		resp.Entries[i] = &pb.BatchEntry{Ip: ip}
+		if info, err := getIPInfo(normalizeIP(ip), o); err != nil {
+			resp.Entries[i].Error = err.Error()
+		} else {
+			resp.Entries[i].Result = pbIPInfo(info)
+		}
+	}
+	return resp, nil
+}
+
+func pbAS(asn as) *pb.AS {
+	return &pb.AS{Number: uint32(asn.Number), Name: asn.Name}
+}
+
+func pbLocation(loc location) *pb.Location {
+	l := &pb.Location{
+		Continent:      loc.Continent.String(),
+		ContinentCode:  loc.ContinentCode,
+		Country:        loc.Country.String(),
+		CountryCode:    loc.CountryCode,
+		City:           loc.City.String(),
+		Latitude:       loc.Latitude,
+		Longitude:      loc.Longitude,
+		AccuracyRadius: uint32(loc.AccuracyRadius),
+		Downgraded:     loc.Downgraded,
+	}
+	for _, sub := range loc.Subdivisions {
+		l.Subdivisions = append(l.Subdivisions, &pb.Subdivision{Name: sub.Name.String(), Code: sub.Code})
+	}
+	if rc := loc.RepresentedCountry; rc != nil {
+		l.RepresentedCountry = &pb.RepresentedCountry{Name: rc.Name.String(), Code: rc.Code, Type: rc.Type}
+	}
+	return l
+}
+
+func pbIPInfo(info ipinfo) *pb.IPInfo {
+	i := &pb.IPInfo{Ip: info.IP.String(), Hostnames: info.Hostnames}
+	if info.AS != nil {
+		i.As = pbAS(*info.AS)
+	}
+	if info.Location != nil {
+		i.Location = pbLocation(*info.Location)
+	}
+	return i
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// options holds the per-request settings shared by all lookup endpoints.
+type options struct {
+	lang             string            // first of the requested languages
+	langs            []string          // all the requested languages
+	fieldLangs       map[string]string // overriding them for some fields, see langFor
+	format           string
+	version          int    // of the response schema
+	crs              string // of GeoJSON coordinates
+	pretty           bool
+	debug            bool
+	explain          bool // include the sources of ipinfo fields, see explain
+	hostnames        bool // reverse DNS of looked up IPs
+	network          bool // include the matched networks
+	localTime        bool // include the current time in the IP's time zone
+	addressType      bool // include the classification of the IP in ipinfo
+	ascii            bool // include ASCII forms of names
+	asnFormats       bool // include textual forms of AS numbers
+	formatted        bool // include a human-readable form of locations
+	fingerprint      bool // include a hash of the result in ipinfo
+	approximate      bool // fall back to country or continent centroids
+	nearestCity      bool // derive major cities from coordinates without a city
+	flat             bool // render ipinfo as flatInfo (GeoJSON features are already flat)
+	subdivisionDepth int  // 0 means all levels
+	geohash          int  // length of the geohash of coordinates, if any
+	redacted         map[string]bool
+	enrich           map[string]bool // enrichers run by getIPInfo
+	date             time.Time       // of the database generations, see generation
+
+	ctx       context.Context     // of the request, for tracing
+	timings   map[string]float64  // database read times, when debugging
+	conflicts map[string]conflict // between databases, when debugging
+	providers map[string]string   // databases results were read from, when debugging or explaining
+	choice    *countryChoice      // of bestCountry, when debugging
+	debugMu   *sync.Mutex         // guards the above, filled in by concurrent lookups
+	readers   readerSet           // pinned for the whole request, if any
+}
+
+func defaultOptions() options {
+	return options{
+		lang:      *lang,
+		langs:     []string{*lang},
+		format:    "json",
+		version:   schemaLatest,
+		crs:       crsWGS84,
+		hostnames: true,
+		redacted:  redactFields,
+		enrich:    map[string]bool{"asn": true, "geo": true, "traits": true},
+		ctx:       context.Background(),
+	}
+}
+
+// requestOptions builds the options of a request. For the language and the
+// format, explicit query parameters take precedence over the Accept-Language
+// and Accept headers, which take precedence over the configured defaults.
+// Unsupported values are ignored, falling back to the next source. As a last
+// resort before the default, the language can be derived from a geo_hint
+// country code. Several comma-separated languages can be given with ?lang=,
+// in which case names are returned for each of them, and the language of some
+// location names set with ?continent_lang=, ?country_lang=, ?city_lang= and
+// ?subdivision_lang=. Redacted fields depend on
+// the bearer token of the request, if any. The database generation selected
+// with ?db= (or ?date=) is ignored without history databases.
+func requestOptions(c *gin.Context) options {
+	o := defaultOptions()
+	o.ctx = c.Request.Context()
+	if ls := queryLangs(c.Query("lang")); len(ls) > 0 {
+		o.langs = ls
+	} else if l := headerLang(c.GetHeader("Accept-Language")); l != "" {
+		o.langs = []string{l}
+	} else if l := hintLangs[strings.ToUpper(c.Query("geo_hint"))]; l != "" {
+		o.langs = []string{l}
+	}
+	o.lang = o.langs[0]
+	for _, field := range localizedFields {
+		if ls := queryLangs(c.Query(field + "_lang")); len(ls) > 0 {
+			if o.fieldLangs == nil {
+				o.fieldLangs = make(map[string]string)
+			}
+			o.fieldLangs[field] = ls[0]
+		}
+	}
+	for _, l := range o.langs {
+		languageRequests.WithLabelValues(l).Inc()
+	}
+	if f := c.Query("format"); f != "" {
+		o.format = f
+	} else if f := acceptFormat(c.GetHeader("Accept")); f != "" {
+		o.format = f
+	}
+	o.version = requestVersion(c.Query("v"), c.GetHeader("Accept"))
+	if crs := c.Query("crs"); crs != "" {
+		o.crs = strings.ToUpper(crs)
+	}
+	o.pretty, _ = strconv.ParseBool(c.Query("pretty"))
+	o.debug, _ = strconv.ParseBool(c.Query("debug"))
+	o.explain, _ = strconv.ParseBool(c.Query("explain"))
+	o.network, _ = strconv.ParseBool(c.Query("network"))
+	o.localTime, _ = strconv.ParseBool(c.Query("local_time"))
+	o.addressType, _ = strconv.ParseBool(c.Query("address_type"))
+	o.ascii, _ = strconv.ParseBool(c.Query("ascii"))
+	o.asnFormats, _ = strconv.ParseBool(c.Query("asn_formats"))
+	o.formatted, _ = strconv.ParseBool(c.Query("formatted"))
+	o.fingerprint, _ = strconv.ParseBool(c.Query("fingerprint"))
+	o.approximate, _ = strconv.ParseBool(c.Query("approximate"))
+	o.nearestCity, _ = strconv.ParseBool(c.Query("nearest_city"))
+	o.flat, _ = strconv.ParseBool(c.Query("flat"))
+	if e := c.Query("enrich"); e != "" {
+		o.enrich = make(map[string]bool)
+		for _, name := range splitList(e) {
+			for _, enricher := range enrichers {
+				if name == enricher {
+					o.enrich[name] = true
+				}
+			}
+		}
+	}
+	if domain, _ := strconv.ParseBool(c.Query("domain")); domain {
+		o.enrich["domain"] = true
+	}
+	if n, err := strconv.Atoi(c.Query("geohash")); err == nil && n > 0 {
+		o.geohash = n
+		if n > maxGeohashLen {
+			o.geohash = maxGeohashLen
+		}
+	} else if b, _ := strconv.ParseBool(c.Query("geohash")); b {
+		o.geohash = defaultGeohashLen
+	}
+	if d, err := strconv.Atoi(c.Query("subdivision_depth")); err == nil && d > 0 {
+		o.subdivisionDepth = d
+	}
+	if len(historyDBs) > 0 {
+		o.date, _ = requestDate(c)
+	}
+	o.redacted = redactionsFor(bearerToken(c))
+	return o
+}
+
+// maxHostLength is the length of the longest valid hostname.
+const maxHostLength = 253
+
+// checkParams rejects requests whose :ip or :host path parameter is longer
+// than any valid one, short-circuiting obviously invalid input (e.g. from
+// scanners) before it gets parsed.
+func checkParams(c *gin.Context) {
+	if ip := c.Param("ip"); len(ip) > *maxIPLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("ip address longer than %d characters", *maxIPLength),
+			"code":  "bad_request",
+		})
+	} else if host := c.Param("host"); len(host) > maxHostLength {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("hostname longer than %d characters", maxHostLength),
+			"code":  "bad_request",
+		})
+	}
+}
+
+// targetIP returns the IP to look up: ip, as given in the path or query string,
+// when valid, or else that of the -lookup_ip_header header when configured;
+// normalized, see normalizeIP.
+func targetIP(c *gin.Context, ip string) string {
+	if *lookupIPHeader == "" || net.ParseIP(ip) != nil {
+		return normalizeIP(ip)
+	}
+	if h := strings.TrimSpace(c.GetHeader(*lookupIPHeader)); net.ParseIP(h) != nil {
+		return normalizeIP(h)
+	}
+	return ip
+}
+
+// formats maps the negotiable MIME types to their format name.
+var formats = map[string]string{
+	gin.MIMEJSON:  "json",
+	gin.MIMEPlain: "text",
+	mimeNDJSON:    "ndjson",
+}
+
+const mimeNDJSON = "application/x-ndjson"
+
+// acceptFormat returns the format of the first media type of the Accept header
+// that is in formats, ignoring its parameters; "" (the default format) if none
+// is. Media types are matched exactly, rather than with gin's NegotiateFormat,
+// which panics on those starting with an offered one (e.g. text/plainx).
+func acceptFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			part = part[:i]
+		}
+		if f := formats[strings.ToLower(strings.TrimSpace(part))]; f != "" {
+			return f
+		}
+	}
+	return ""
+}
+
+// lookup runs the given kind of lookup (asn, geo, anon, domain, all, ptr, or
+// host for a forward DNS lookup) and renders it.
+func lookup(c *gin.Context, kind, ip string) {
+	o := requestOptions(c)
+	if _, err := requestDate(c); err != nil && len(historyDBs) > 0 {
+		render(c, o, http.StatusBadRequest, gin.H{"error": err.Error(), "code": "bad_request"})
+		return
+	}
+	if *lookupIPHeader != "" && kind != "host" && net.ParseIP(ip) == nil {
+		render(c, o, http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid ip address %q, and no valid one in the %s header", ip, *lookupIPHeader),
+			"code":  "bad_request",
+		})
+		return
+	}
+	if o.explain && kind == "all" && !explainAllowed(c) {
+		render(c, o, http.StatusForbidden, gin.H{
+			"error": "explanations require the reload token",
+			"code":  "forbidden",
+		})
+		return
+	}
+
+	// Precomputed results, if any (they only hold the default fields, see snapshotable)
+	if info, ok := snap.get(ip, o.lang); ok && snapshotable(o) {
+		o.redactInfo(&info)
+		if o.fingerprint {
+			info.Fingerprint = fingerprint(info)
+		}
+		switch kind {
+		case "asn":
+			if info.AS != nil {
+				audit.record(c, kind, ip, *info.AS, nil)
+				render(c, o, http.StatusOK, info.AS)
+				return
+			}
+		case "geo":
+			if info.Location != nil {
+				audit.record(c, kind, ip, *info.Location, nil)
+				render(c, o, http.StatusOK, info.Location)
+				return
+			}
+		case "all":
+			audit.record(c, kind, ip, info, nil)
+			if o.flat && o.format != "geojson" {
+				render(c, o, http.StatusOK, toFlat(info))
+				return
+			}
+			render(c, o, http.StatusOK, info)
+			return
+		}
+	}
+
+	var data interface{}
+	var err error
+	switch kind {
+	case "asn":
+		var asn as
+		err = cachedLookup(o, kind, ip, &asn, func() (err error) {
+			asn, err = getAS(ip, o)
+			return err
+		})
+		data = asn
+	case "geo":
+		var loc location
+		err = cachedLookup(o, kind, ip, &loc, func() (err error) {
+			loc, err = getLocation(ip, o)
+			return err
+		})
+		data = loc
+	case "anon":
+		data, err = getAnonymity(ip, o)
+	case "domain":
+		data, err = getDomain(ip, o)
+	case "all":
+		var info ipinfo
+		if o.explain {
+			cached := false
+			if resultCache != nil {
+				_, cached = resultCache.get(o.ctx, cacheKey(kind, ip, o))
+			}
+			o.providers, o.debugMu = make(map[string]string), new(sync.Mutex)
+			if info, err = getIPInfo(ip, o); err == nil {
+				info.Explain = explain(info, o, cached)
+			}
+			data = info
+			break
+		}
+		err = cachedLookup(o, kind, ip, &info, func() (err error) {
+			info, err = getIPInfo(ip, o)
+			return err
+		})
+		data = info
+	case "ptr":
+		data, err = getReverse(c.Request.Context(), ip)
+	case "host":
+		data, err = getForward(c.Request.Context(), ip, o)
+	default:
+		render(c, o, http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid lookup type %q", kind),
+			"code":  "bad_request",
+		})
+		return
+	}
+	audit.record(c, kind, ip, data, err)
+	if err != nil {
+		status, body := errorResponse(err)
+		if status == http.StatusServiceUnavailable {
+			c.Header("Retry-After", "1")
+		}
+		render(c, o, status, body)
+		return
+	}
+	if info, ok := data.(ipinfo); ok && o.flat && o.format != "geojson" {
+		data = toFlat(info)
+	}
+	render(c, o, http.StatusOK, data)
+}
+
+// errorResponse maps a lookup error to the status code and body it is
+// reported with; the code lets clients tell retriable errors apart.
+func errorResponse(err error) (int, gin.H) {
+	if status, code, ok := dnsErrorStatus(err); ok {
+		return status, gin.H{"error": err.Error(), "code": code}
+	}
+	status, code := http.StatusInternalServerError, "internal"
+	switch {
+	case errors.Is(err, errInvalidIP), errors.Is(err, errInvalidHostname):
+		status, code = http.StatusBadRequest, "bad_request"
+	case errors.Is(err, errNoGeneration):
+		status, code = http.StatusNotFound, "no_generation"
+	case errors.Is(err, errUnsupportedFamily):
+		status, code = http.StatusNotFound, "unsupported_ip_family"
+	case errors.Is(err, errNotFound):
+		status, code = http.StatusNotFound, "not_found"
+	case errors.Is(err, errNotConfigured):
+		status, code = http.StatusNotImplemented, "not_configured"
+	case errors.Is(err, ErrDatabaseClosed):
+		status, code = http.StatusServiceUnavailable, "database_closed"
+	case errors.Is(err, errNotLoaded):
+		status, code = http.StatusServiceUnavailable, "database_not_loaded"
+	}
+	return status, gin.H{"error": err.Error(), "code": code}
+}
+
+// renderFormats are the formats responses can be rendered in.
+var renderFormats = []string{"json", "text", "geojson", "ndjson"}
+
+// render writes obj in the requested format, defaulting to JSON, and schema
+// version (GeoJSON has its own). Unsupported formats are rejected with a 406
+// listing the supported ones, unless configured to fall back to JSON.
+func render(c *gin.Context, o options, status int, obj interface{}) {
+	supported := false
+	for _, f := range renderFormats {
+		supported = supported || f == o.format
+	}
+	if !supported && *unsupportedFormat == "json" {
+		o.format = "json"
+	} else if !supported {
+		writeJSON(c, o, http.StatusNotAcceptable, gin.H{
+			"error":   fmt.Sprintf("unsupported format %q", o.format),
+			"code":    "not_acceptable",
+			"formats": renderFormats,
+		})
+		return
+	}
+	switch o.format {
+	case "text":
+		text, err := toText(toVersion(obj, o.version))
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.String(status, text)
+	case "geojson":
+		if status == http.StatusOK {
+			var err error
+			if obj, status, err = toGeoJSON(obj, o.crs); errors.Is(err, errNoCoordinates) {
+				obj = gin.H{"error": err.Error(), "code": "no_coordinates"}
+			} else if err != nil {
+				obj = gin.H{"error": err.Error(), "code": "bad_request"}
+			}
+		}
+		writeJSON(c, o, status, obj)
+	case "ndjson":
+		writeNDJSON(c, status, toVersion(obj, o.version))
+	default:
+		writeJSON(c, o, status, wrapRoot(status, toVersion(obj, o.version)))
+	}
+}
+
+// wrapRoot nests successful responses under -root_key, when configured. Errors
+// are left as is, for them to keep the same shape whatever the configuration.
+func wrapRoot(status int, obj interface{}) interface{} {
+	if *rootKey == "" || status >= http.StatusBadRequest {
+		return obj
+	}
+	return map[string]interface{}{*rootKey: obj}
+}
+
+func writeJSON(c *gin.Context, o options, status int, obj interface{}) {
+	if !o.pretty && *jsonEncoding == "stdlib" {
+		c.JSON(status, obj)
+		return
+	}
+	var buf bytes.Buffer
+	enc := newJSONEncoder(&buf)
+	if o.pretty {
+		// Only encoding/json indents the output of MarshalJSON methods too
+		enc = json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(obj); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	b := buf.Bytes()
+	if !o.pretty {
+		b = bytes.TrimSuffix(b, []byte("\n"))
+	}
+	c.Data(status, "application/json; charset=utf-8", b)
+}
+
+// writeNDJSON writes the elements of a list one per line, any other object
+// being written on a single line; each of them nested under -root_key.
+func writeNDJSON(c *gin.Context, status int, obj interface{}) {
+	var buf bytes.Buffer
+	enc := newJSONEncoder(&buf)
+	var err error
+	if v := reflect.ValueOf(obj); v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len() && err == nil; i++ {
+			err = enc.Encode(wrapRoot(status, v.Index(i).Interface()))
+		}
+	} else {
+		err = enc.Encode(wrapRoot(status, obj))
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(status, mimeNDJSON, buf.Bytes())
+}
+
+// toText flattens obj into sorted "Key.SubKey: value" lines.
+func toText(obj interface{}) (string, error) {
+	fields, err := flatten(obj)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, 0, len(fields))
+	for k, v := range fields {
+		lines = append(lines, strings.TrimSpace(k+": "+v))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// flatten maps the "Key.SubKey" paths of obj's JSON form to their values.
+func flatten(obj interface{}) (map[string]string, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, sub := range v {
+				walk(strings.TrimPrefix(prefix+"."+k, "."), sub)
+			}
+		case []interface{}:
+			for i, sub := range v {
+				walk(fmt.Sprintf("%s.%d", prefix, i), sub)
+			}
+		case nil:
+			fields[prefix] = ""
+		default:
+			fields[prefix] = fmt.Sprint(v)
+		}
+	}
+	walk("", tree)
+	return fields, nil
+}
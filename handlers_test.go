@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testContext returns the context of a GET request of target with headers.
+func testContext(target string, headers map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	return c, w
+}
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{invalidIP("notanip"), http.StatusBadRequest, "bad_request"},
+		{fmt.Errorf("%w %q", errInvalidHostname, "-"), http.StatusBadRequest, "bad_request"},
+		{errNotFound, http.StatusNotFound, "not_found"},
+		{errUnsupportedFamily, http.StatusNotFound, "unsupported_ip_family"},
+		{ErrDatabaseClosed, http.StatusServiceUnavailable, "database_closed"},
+		{errors.New("read error"), http.StatusInternalServerError, "internal"},
+	}
+	for _, tt := range tests {
+		status, body := errorResponse(tt.err)
+		if status != tt.status || body["code"] != tt.code {
+			t.Errorf("errorResponse(%v) = %d %v, want %d %s", tt.err, status, body["code"], tt.status, tt.code)
+		}
+	}
+}
+
+func TestRequestOptionsPrecedence(t *testing.T) {
+	tests := []struct {
+		target       string
+		headers      map[string]string
+		lang, format string
+	}{
+		{"/ipinfo/8.8.8.8", nil, "en", "json"},
+		{"/ipinfo/8.8.8.8?lang=ja", map[string]string{"Accept-Language": "en"}, "ja", "json"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept-Language": "de"}, "de", "json"},
+		{"/ipinfo/8.8.8.8?lang=xx", map[string]string{"Accept-Language": "fr"}, "fr", "json"},     // unsupported query language
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept-Language": ";;q=,garbage"}, "en", "json"},   // unparseable header
+		{"/ipinfo/8.8.8.8?geo_hint=jp", map[string]string{"Accept-Language": "ru"}, "ru", "json"}, // header over hint
+		{"/ipinfo/8.8.8.8?geo_hint=jp", nil, "ja", "json"},
+		{"/ipinfo/8.8.8.8?format=text", map[string]string{"Accept": "application/json"}, "en", "text"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "text/plain"}, "en", "text"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "application/x-ndjson"}, "en", "ndjson"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "image/png"}, "en", "json"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "image/png, text/plain;q=0.5"}, "en", "text"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "application/jsonl"}, "en", "json"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "text/plainx"}, "en", "json"},
+		{"/ipinfo/8.8.8.8", map[string]string{"Accept": "application/json-patch+json"}, "en", "json"},
+	}
+	for _, tt := range tests {
+		c, _ := testContext(tt.target, tt.headers)
+		if o := requestOptions(c); o.lang != tt.lang || o.format != tt.format {
+			t.Errorf("%s %v: lang %s, format %s; want %s, %s", tt.target, tt.headers, o.lang, o.format, tt.lang, tt.format)
+		}
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	r := gin.New()
+	r.GET("/ipinfo/:ip", checkParams, func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/host/:host", checkParams, func(c *gin.Context) { c.Status(http.StatusOK) })
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/ipinfo/8.8.8.8", http.StatusOK},
+		{"/ipinfo/ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255", http.StatusOK},
+		{"/ipinfo/" + strings.Repeat("1", *maxIPLength+1), http.StatusBadRequest},
+		{"/ipinfo/" + strings.Repeat("a", 10000), http.StatusBadRequest},
+		{"/host/" + strings.Repeat("a", maxHostLength), http.StatusOK},
+		{"/host/" + strings.Repeat("a", maxHostLength+1), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %.40s... status = %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	defer func(mode string) { *unsupportedFormat = mode }(*unsupportedFormat)
+	*unsupportedFormat = "reject"
+	c, w := testContext("/asn/8.8.8.8?format=yaml", nil)
+	lookup(c, "asn", "8.8.8.8")
+	var body struct {
+		Code    string
+		Formats []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotAcceptable || body.Code != "not_acceptable" || !reflect.DeepEqual(body.Formats, renderFormats) {
+		t.Errorf("?format=yaml = %d %+v, want %d not_acceptable listing %v", w.Code, body, http.StatusNotAcceptable, renderFormats)
+	}
+
+	*unsupportedFormat = "json"
+	c, w = testContext("/asn/8.8.8.8?format=yaml", nil)
+	lookup(c, "asn", "8.8.8.8")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), gin.MIMEJSON) {
+		t.Errorf("?format=yaml with -unsupported_format=json = %d %s, want %d JSON", w.Code, w.Header().Get("Content-Type"), http.StatusOK)
+	}
+}
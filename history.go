@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// historyDBs are the older generations of the ASN and GeoIP databases, which
+// ?date= lookups can be served from.
+var historyDBs []*database
+
+// errNoGeneration is returned by dated lookups when every generation of the
+// database was built after the requested date.
+var errNoGeneration = errors.New("no database generation built at or before the requested date")
+
+// probeHistory accepts both ASN and GeoIP generations.
+func probeHistory(r *geoip2.Reader) error {
+	if _, err := r.ASN(probeIP); err == nil {
+		return nil
+	}
+	_, err := r.City(probeIP)
+	return err
+}
+
+// parseDate parses the ?date= of dated lookups, as a RFC 3339 time or a day,
+// which stands for its end. It returns the zero time for an empty date.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expecting YYYY-MM-DD or RFC 3339", s)
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// requestDate returns the date of the database generation selected with
+// ?db=<date> (or its ?date= alias), or the zero time for lookups to be served
+// as usual, by the current databases: without one, or with ?db=latest.
+func requestDate(c *gin.Context) (time.Time, error) {
+	switch db := c.Query("db"); db {
+	case "":
+		return parseDate(c.Query("date"))
+	case "latest":
+		return time.Time{}, nil
+	default:
+		return parseDate(db)
+	}
+}
+
+// generation returns the latest generation of current built at or before
+// date, among current itself and the history databases of the same type,
+// along with its build date.
+func generation(current *database, date time.Time) (*database, time.Time, error) {
+	var best *database
+	var bestBuilt time.Time
+	for _, db := range append([]*database{current}, historyDBs...) {
+		r := db.reader()
+		if r == nil || (db != current && current.probe(r.Reader) != nil) {
+			continue
+		}
+		built := r.buildDate()
+		if !built.After(date) && (best == nil || built.After(bestBuilt)) {
+			best, bestBuilt = db, built
+		}
+	}
+	if best == nil {
+		return nil, time.Time{}, errNoGeneration
+	}
+	return best, bestBuilt, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// privateNets are the private-use ranges of RFC 1918 and RFC 4193.
+var privateNets = parseNets("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+var (
+	uniqueLocalNet    = parseNets("fc00::/7")[0]
+	documentationNets = parseNets("192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32")
+)
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isPrivate(ip net.IP) bool {
+	return inNets(ip, privateNets)
+}
+
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressType classifies ip: unspecified, loopback, broadcast, multicast,
+// link-local, documentation, unique-local, private or (other) unicast.
+func addressType(ip net.IP) string {
+	switch {
+	case ip.IsUnspecified():
+		return "unspecified"
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.Equal(net.IPv4bcast):
+		return "broadcast"
+	case ip.IsMulticast():
+		return "multicast"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case inNets(ip, documentationNets):
+		return "documentation"
+	case uniqueLocalNet.Contains(ip):
+		return "unique-local"
+	case isPrivate(ip):
+		return "private"
+	default:
+		return "unicast"
+	}
+}
+
+// isGlobal reports whether ip is a publicly routable unicast address.
+func isGlobal(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !isPrivate(ip) && !inNets(ip, documentationNets)
+}
+
+// normalizeIP returns the IPv4 form of IPv4-mapped (::ffff:a.b.c.d) and
+// IPv4-compatible (::a.b.c.d, deprecated) IPv6 addresses with
+// -normalize_v4_mapped, for them to be looked up and echoed as the IPv4
+// addresses they stand for. Other input, including :: and ::1, is returned as
+// is, as are those addresses otherwise: they are then IPv6 ones, although
+// databases may alias their networks to the IPv4 ones.
+func normalizeIP(s string) string {
+	if !*normalizeV4Mapped || !strings.Contains(s, ":") {
+		return s
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	for _, b := range ip[:12] {
+		if b != 0 {
+			return s
+		}
+	}
+	if compat := ip[12:]; compat[0]|compat[1]|compat[2] != 0 || compat[3] > 1 {
+		return compat.String()
+	}
+	return s
+}
+
+// truncateIP returns the /24 (IPv4) or /48 (IPv6) network of ip, as
+// anonymized audit logs and traces have it.
+func truncateIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// validation describes an IP address without looking it up.
+type validation struct {
+	Valid       bool
+	Family      string `json:",omitempty"`
+	Canonical   string `json:",omitempty"`
+	AddressType string `json:",omitempty"`
+	IsPrivate   bool
+	IsGlobal    bool
+}
+
+// validate parses ip; anything net.ParseIP rejects is simply not valid, which
+// is a regular answer rather than a client error.
+func validate(ip string) validation {
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return validation{}
+	}
+	return validation{
+		Valid:       true,
+		Family:      ipFamily(ipaddr),
+		Canonical:   ipaddr.String(),
+		AddressType: addressType(ipaddr),
+		IsPrivate:   isPrivate(ipaddr),
+		IsGlobal:    isGlobal(ipaddr),
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestNormalizeIP(t *testing.T) {
+	defer func(b bool) { *normalizeV4Mapped = b }(*normalizeV4Mapped)
+	tests := []struct {
+		ip, normalized string
+	}{
+		{"8.8.8.8", "8.8.8.8"},
+		{"::ffff:8.8.8.8", "8.8.8.8"},
+		{"::FFFF:808:808", "8.8.8.8"},
+		{"::8.8.8.8", "8.8.8.8"},
+		{"::0.0.0.2", "0.0.0.2"},
+		{"::", "::"},
+		{"::1", "::1"},
+		{"2001:4860:4860::8888", "2001:4860:4860::8888"},
+		{"::ffff:notanip", "::ffff:notanip"},
+	}
+	for _, tt := range tests {
+		*normalizeV4Mapped = true
+		if got := normalizeIP(tt.ip); got != tt.normalized {
+			t.Errorf("normalizeIP(%q) = %q, want %q", tt.ip, got, tt.normalized)
+		}
+		*normalizeV4Mapped = false
+		if got := normalizeIP(tt.ip); got != tt.ip {
+			t.Errorf("normalizeIP(%q) without -normalize_v4_mapped = %q, want it unchanged", tt.ip, got)
+		}
+	}
+}
+
+func TestNormalizedLookup(t *testing.T) {
+	defer func(b bool) { *normalizeV4Mapped = b }(*normalizeV4Mapped)
+	// Without aliases to the IPv4 networks, mapped addresses are IPv6 ones
+	withDatabase(t, &asnDB, "asn", "testdata/asn-v4.mmdb")
+	*normalizeV4Mapped = true
+	info, err := getIPInfo(normalizeIP("::ffff:8.8.8.8"), testOptions())
+	if err != nil || info.IP.String() != "8.8.8.8" || info.AS == nil || info.AS.Name != "GOOGLE" {
+		t.Errorf("normalized lookup = %v %v, %v; want 8.8.8.8 in GOOGLE", info.IP, info.AS, err)
+	}
+	*normalizeV4Mapped = false
+	info, err = getIPInfo(normalizeIP("::ffff:8.8.8.8"), testOptions())
+	if err != nil || info.AS != nil || len(info.Unsupported) != 1 {
+		t.Errorf("lookup without normalization = AS %v, unsupported %v, %v; want the AS unsupported", info.AS, info.Unsupported, err)
+	}
+}
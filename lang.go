@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// languages are the ones names are available in, in (Geo|DB-)IP databases.
+var languages = []string{"de", "en", "es", "fr", "ja", "pt-BR", "ru", "zh-CN"}
+
+// relatedLangs maps language variants the databases have no names in, such
+// as other scripts, to the related language used in their place.
+var relatedLangs map[string]string
+
+// supportedLang reports whether names are available in lang, or in a related
+// language.
+func supportedLang(lang string) bool {
+	_, ok := relatedLangs[lang]
+	return ok || availableLang(lang)
+}
+
+// availableLang reports whether the databases have names in lang.
+func availableLang(lang string) bool {
+	for _, l := range languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRelatedLangs parses "variant:lang" pairs, skipping the ones whose
+// language names aren't available in.
+func parseRelatedLangs(s string) map[string]string {
+	related := make(map[string]string)
+	for _, pair := range splitList(s) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) == 2 && parts[0] != "" && availableLang(parts[1]) {
+			related[parts[0]] = parts[1]
+		}
+	}
+	return related
+}
+
+// localized is a name in each of the requested languages. It is rendered as
+// a plain string when a single language was requested, and as an object keyed
+// by language code otherwise.
+type localized map[string]string
+
+func (l localized) MarshalJSON() ([]byte, error) {
+	if len(l) > 1 {
+		return marshalJSON(map[string]string(l))
+	}
+	return marshalJSON(l.String())
+}
+
+// UnmarshalJSON accepts both rendered forms, so results can be read back.
+func (l *localized) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*l = localized{"": name}
+		return nil
+	}
+	return json.Unmarshal(b, (*map[string]string)(l))
+}
+
+// in returns the name in lang or, for names localized in a single other
+// language of their own (see langFor), in that one.
+func (l localized) in(lang string) string {
+	if name, ok := l[lang]; ok || len(l) != 1 {
+		return name
+	}
+	return l.String()
+}
+
+// String returns the name, in any of its languages if there are several.
+func (l localized) String() string {
+	for _, name := range l {
+		return name
+	}
+	return ""
+}
+
+// localizedFields are the location names whose language can be set with
+// ?<field>_lang=, see langFor.
+var localizedFields = []string{"continent", "country", "city", "subdivision"}
+
+// langFor returns o localizing the names of field in the language requested
+// for it, if any, rather than in the languages of the request.
+func (o options) langFor(field string) options {
+	if l := o.fieldLangs[field]; l != "" {
+		o.lang, o.langs = l, []string{l}
+	}
+	return o
+}
+
+// localize returns names in each of the requested languages, along with an
+// ASCII form under the "ascii" key when requested.
+func (o options) localize(names map[string]string) localized {
+	l := make(localized, len(o.langs)+1)
+	for _, lang := range o.langs {
+		l[lang] = localizedName(names, lang)
+	}
+	if o.ascii {
+		l["ascii"] = asciiName(l[o.lang], names[defaultLang])
+	}
+	return l
+}
+
+// localizedName returns the name in the requested language, falling back to
+// its related language if any (e.g. zh-TW => zh-CN), then to English when the
+// database has no (or an empty) translation for it, and finally to the
+// configured placeholder. Name fields are never omitted from responses, not
+// having omitempty, so the default (empty) placeholder renders unknown names
+// as empty strings. Invalid UTF-8 sequences are replaced. Fallbacks are
+// counted by languageFallbacks.
+func localizedName(names map[string]string, lang string) string {
+	used := nameLanguage(names, lang)
+	if used != lang && len(names) > 0 {
+		fallback := used
+		if fallback == "" {
+			fallback = "unknown"
+		}
+		languageFallbacks.WithLabelValues(lang, fallback).Inc()
+	}
+	name := names[used]
+	if used == "" {
+		name = *unknownName
+	}
+	return strings.ToValidUTF8(name, "\uFFFD")
+}
+
+// nameLanguage returns the language localizedName picks the name in, none
+// when it falls back to the placeholder.
+func nameLanguage(names map[string]string, lang string) string {
+	for _, l := range []string{lang, relatedLangs[lang], defaultLang} {
+		if names[l] != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// stripMarks removes diacritics, e.g. São Paulo => Sao Paulo.
+var stripMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// asciiName returns an ASCII form of name: stripped of its diacritics, or else
+// the English name, or else nothing rather than non-ASCII characters.
+func asciiName(name, english string) string {
+	for _, n := range []string{name, english} {
+		if stripped, _, err := transform.String(stripMarks, n); err == nil && isASCII(stripped) {
+			return stripped
+		}
+	}
+	return ""
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// queryLangs returns the supported languages of a comma-separated list.
+func queryLangs(s string) []string {
+	var langs []string
+	for _, l := range splitList(s) {
+		if supportedLang(l) {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+// hintLangs maps country codes to the language used for their geo_hint.
+var hintLangs map[string]string
+
+// parseHintLangs parses "CC:lang" pairs, skipping unsupported languages.
+func parseHintLangs(s string) map[string]string {
+	hints := make(map[string]string)
+	for _, pair := range splitList(s) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) == 2 && supportedLang(parts[1]) {
+			hints[strings.ToUpper(parts[0])] = parts[1]
+		}
+	}
+	return hints
+}
+
+// headerLang returns the supported language of an Accept-Language header
+// with the highest quality value, matching either the full tag (including
+// related variants) or its primary subtag (en-US => en). Tags with equal weights keep their order.
+func headerLang(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range splitList(header) {
+		params := strings.Split(part, ";")
+		w := weighted{tag: strings.TrimSpace(params[0]), q: 1}
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					w.q = q
+				}
+			}
+		}
+		if w.q > 0 && w.tag != "*" {
+			tags = append(tags, w)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, w := range tags {
+		for variant := range relatedLangs {
+			if strings.EqualFold(variant, w.tag) {
+				return variant
+			}
+		}
+		primary := strings.SplitN(w.tag, "-", 2)[0]
+		var match string
+		for _, l := range languages {
+			if strings.EqualFold(l, w.tag) {
+				return l
+			}
+			if match == "" && strings.EqualFold(strings.SplitN(l, "-", 2)[0], primary) {
+				match = l
+			}
+		}
+		if match != "" {
+			return match
+		}
+	}
+	return ""
+}
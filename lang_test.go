@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocalizedNames(t *testing.T) {
+	tests := []struct {
+		lang, continent, country, city string
+	}{
+		{"de", "North America-de", "United States-de", "Mountain View"},
+		{"en", "North America", "United States", "Mountain View"},
+		{"es", "North America", "United States", "Mountain View"},
+		{"fr", "North America", "United States", "Mountain View"},
+		{"ja", "North America-ja", "United States-ja", "Mountain View-ja"},
+		{"pt-BR", "North America", "United States", "Mountain View"},
+		{"ru", "North America", "United States", "Mountain View"},
+		{"zh-CN", "North America", "United States-zh", "Mountain View"},
+	}
+	for _, tt := range tests {
+		o := testOptions()
+		o.lang, o.langs = tt.lang, []string{tt.lang}
+		loc, err := getLocation("8.8.8.8", o)
+		if err != nil {
+			t.Fatalf("getLocation(8.8.8.8) in %s: %v", tt.lang, err)
+		}
+		if got := [3]string{loc.Continent[tt.lang], loc.Country[tt.lang], loc.City[tt.lang]}; got != [3]string{tt.continent, tt.country, tt.city} {
+			t.Errorf("names in %s = %q, want %q", tt.lang, got, [3]string{tt.continent, tt.country, tt.city})
+		}
+	}
+}
+
+func TestLocalizedNameFallbacks(t *testing.T) {
+	defer func(s string) { *unknownName = s }(*unknownName)
+	*unknownName = "?"
+	tests := []struct {
+		names map[string]string
+		lang  string
+		want  string
+	}{
+		{map[string]string{"en": "Germany", "fr": "Allemagne"}, "fr", "Allemagne"},
+		{map[string]string{"en": "Germany", "fr": ""}, "fr", "Germany"}, // empty translation
+		{map[string]string{"en": "Germany"}, "ja", "Germany"},
+		{map[string]string{"de": "Deutschland"}, "ja", "?"},
+		{nil, "en", "?"},
+	}
+	for _, tt := range tests {
+		if got := localizedName(tt.names, tt.lang); got != tt.want {
+			t.Errorf("localizedName(%v, %s) = %q, want %q", tt.names, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderLang(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"ja", "ja"},
+		{"ja;q=0.9, en;q=0.8", "ja"},
+		{"en;q=0.8, ja;q=0.9", "ja"},
+		{"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5", "fr"},
+		{"en-US,en;q=0.9", "en"},
+		{"pt-BR,pt;q=0.9,en-US;q=0.8", "pt-BR"},
+		{"pt-PT,pt;q=0.9", "pt-PT"}, // related variant
+		{"nl-NL,nl;q=0.9,de;q=0.8", "de"},
+		{"ko-KR,ko;q=0.9", ""},
+		{"de;q=0, fr", "fr"},
+		{"zh-Hans-CN;q=0.5, ru;q=0.4", "zh-CN"},
+		{"*", ""},
+	}
+	for _, tt := range tests {
+		if got := headerLang(tt.header); got != tt.want {
+			t.Errorf("headerLang(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRelatedLangs(t *testing.T) {
+	for _, lang := range []string{"zh-TW", "zh-HK"} {
+		o := testOptions()
+		o.lang, o.langs = lang, []string{lang}
+		loc, err := getLocation("8.8.8.8", o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc.Country[lang] != "United States-zh" || loc.Continent[lang] != "North America" {
+			t.Errorf("%s names = %q, %q; want the zh-CN country and the English continent", lang, loc.Country[lang], loc.Continent[lang])
+		}
+	}
+	names := map[string]string{"en": "Taiwan", "zh-CN": "台湾"}
+	if got := localizedName(names, "zh-TW"); got != "台湾" {
+		t.Errorf("zh-TW name = %q, want the zh-CN one", got)
+	}
+	if got := localizedName(map[string]string{"en": "Taiwan"}, "zh-TW"); got != "Taiwan" {
+		t.Errorf("zh-TW name without zh-CN = %q, want the English one", got)
+	}
+	related := parseRelatedLangs("zh-TW:zh-CN,sr-Latn:sr,pt-PT:pt-BR,:en")
+	if want := map[string]string{"zh-TW": "zh-CN", "pt-PT": "pt-BR"}; !reflect.DeepEqual(related, want) {
+		t.Errorf("parseRelatedLangs = %v, want %v", related, want)
+	}
+}
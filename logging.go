@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessLogger returns the access log middleware for the given format:
+// "default" (Gin's own), "json" (one object per line) or "w3c" (W3C Extended
+// Log Format, whose header is written right away).
+func accessLogger(format string) gin.HandlerFunc {
+	switch format {
+	case "json":
+		return gin.LoggerWithFormatter(jsonLogLine)
+	case "w3c":
+		fmt.Fprint(gin.DefaultWriter, "#Version: 1.0\n#Fields: date time c-ip cs-method cs-uri status time-taken\n")
+		return gin.LoggerWithFormatter(w3cLogLine)
+	default:
+		return gin.Logger()
+	}
+}
+
+func jsonLogLine(p gin.LogFormatterParams) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"time":     p.TimeStamp.UTC().Format(time.RFC3339Nano),
+		"client":   p.ClientIP,
+		"method":   p.Method,
+		"path":     p.Path,
+		"status":   p.StatusCode,
+		"duration": p.Latency.Seconds(),
+		"error":    p.ErrorMessage,
+	})
+	return string(b) + "\n"
+}
+
+// w3cLogLine follows the #Fields: header: times are UTC, time-taken is in
+// seconds and missing values are "-".
+func w3cLogLine(p gin.LogFormatterParams) string {
+	ts := p.TimeStamp.UTC()
+	return fmt.Sprintf("%s %s %s %s %s %d %.3f\n",
+		ts.Format("2006-01-02"), ts.Format("15:04:05"),
+		w3cValue(p.ClientIP), p.Method, w3cValue(p.Path), p.StatusCode, p.Latency.Seconds())
+}
+
+func w3cValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,848 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/sync/errgroup"
+)
+
+// errNotConfigured is returned when looking up an optional database that
+// was not configured.
+var errNotConfigured = errors.New("database not configured")
+
+// errNotFound is returned when looking up an IP the database has no data for.
+var errNotFound = errors.New("ip address not found in database")
+
+// errInvalidIP is returned for input which isn't an IP address, see invalidIP.
+var errInvalidIP = errors.New("invalid ip address")
+
+// invalidIP returns errInvalidIP for the given input.
+func invalidIP(ip string) error {
+	return fmt.Errorf("%w %q", errInvalidIP, ip)
+}
+
+// errUnsupportedFamily is returned when looking up an IPv6 address in an
+// IPv4-only database, which has no data for it either.
+var errUnsupportedFamily = fmt.Errorf("ip family not supported by the database (%w)", errNotFound)
+
+type as struct {
+	Number      uint
+	Name        string
+	Normalized  string     `json:",omitempty"` // Name, with -org_normalization
+	ASPlain     string     `json:",omitempty"` // textual forms of Number, with ?asn_formats=true
+	ASDot       string     `json:",omitempty"`
+	Prefixed    string     `json:",omitempty"`
+	CountryCode string     `json:",omitempty"` // where the AS is registered
+	Network     string     `json:",omitempty"`
+	BuildDate   *time.Time `json:",omitempty"` // of the database generation the AS was read from
+	Overridden  bool       `json:",omitempty"` // patched by -overrides
+	Incomplete  bool       `json:",omitempty"` // the database has either no number or no name
+}
+
+type location struct {
+	Continent          localized
+	ContinentCode      string
+	Country            localized
+	CountryCode        string
+	CountryCode3       string `json:",omitempty"` // ISO 3166-1 alpha-3
+	City               localized
+	Subdivisions       []subdivision
+	Formatted          localized           `json:",omitempty"` // with ?formatted=true
+	RepresentedCountry *representedCountry `json:",omitempty"`
+	Latitude           *float64            `json:",omitempty"`
+	Longitude          *float64            `json:",omitempty"`
+	AccuracyRadius     uint16              `json:",omitempty"` // in km
+	Geohash            string              `json:",omitempty"` // with ?geohash=
+	TimeZone           string              `json:",omitempty"`
+	LocalTime          string              `json:",omitempty"` // with ?local_time=true
+	Downgraded         bool                `json:",omitempty"` // city-level data was too inaccurate
+	Approximated       string              `json:",omitempty"` // centroid the coordinates are of, with ?approximate=true
+	Smoothed           string              `json:",omitempty"` // strategy of -geo_smoothing, when applied
+	NearestCity        *nearestCity        `json:",omitempty"` // derived from the coordinates, with ?nearest_city=true
+	Unnamed            bool                `json:",omitempty"` // the database has no names at all for the IP
+	IsAnycast          bool                `json:",omitempty"` // geolocation is unreliable for anycast networks
+	Network            string              `json:",omitempty"`
+	BuildDate          *time.Time          `json:",omitempty"` // of the database generation the location was read from
+	Overridden         bool                `json:",omitempty"` // patched by -overrides
+
+	registeredCountry string // by the ISP, for the debug info
+	placeholder       bool   // the coordinates are the 0,0 of -missing_coords=zero
+}
+
+// hasCoords reports whether loc has coordinates, other than placeholders.
+func (l *location) hasCoords() bool {
+	return l.Latitude != nil && l.Longitude != nil && !l.placeholder
+}
+
+// subdivision is a region (state, province, county...) the IP is located in.
+type subdivision struct {
+	Name localized
+	Code string
+}
+
+// representedCountry is the country represented by users of the IP, such as
+// for embassies or military bases abroad; Type tells which (e.g. "military").
+type representedCountry struct {
+	Name localized
+	Code string
+	Type string
+}
+
+// representedTypes are the types of the represented countries included in
+// locations, all of them when nil.
+var representedTypes map[string]bool
+
+// MarshalJSON renders missing coordinates as null rather than omitting them
+// when configured to do so.
+func (l location) MarshalJSON() ([]byte, error) {
+	type plain location
+	if *missingCoords != "null" || l.Latitude != nil {
+		return marshalJSON(plain(l))
+	}
+	return marshalJSON(struct {
+		plain
+		Latitude, Longitude *float64
+	}{plain: plain(l)})
+}
+
+// accuracyDecimals is the rounding curve of -coord_rounding=accuracy: the
+// decimal places kept for coordinates up to a given accuracy radius (km), one
+// decimal of a degree being about 11 km, and two 1.1 km. Coordinates with a
+// larger accuracy radius are rounded to the degree (111 km).
+var accuracyDecimals = []struct {
+	radius   uint16
+	decimals int
+}{
+	{3, 2},
+	{35, 1},
+}
+
+// decimals returns the number of decimal places the coordinates are rounded
+// to, -1 for none: the configured precision, if any, lowered to match the
+// accuracy radius in accuracy mode (when it is known).
+func (l *location) decimals() int {
+	digits := *coordPrecision
+	if *coordRounding != "accuracy" || l.AccuracyRadius == 0 {
+		return digits
+	}
+	d := 0
+	for _, step := range accuracyDecimals {
+		if l.AccuracyRadius <= step.radius {
+			d = step.decimals
+			break
+		}
+	}
+	if digits < 0 || d < digits {
+		digits = d
+	}
+	return digits
+}
+
+// roundCoords reduces the precision of the coordinates to the configured
+// number of decimal places, if any, see decimals.
+func (l *location) roundCoords() {
+	digits := l.decimals()
+	if digits < 0 || l.Latitude == nil || l.Longitude == nil {
+		return
+	}
+	scale := math.Pow(10, float64(digits))
+	lat, long := math.Round(*l.Latitude*scale)/scale, math.Round(*l.Longitude*scale)/scale
+	l.Latitude, l.Longitude = &lat, &long
+}
+
+// anonymity flags from the (optional) Anonymous IP database.
+type anonymity struct {
+	IsAnonymous       bool
+	IsVPN             bool
+	IsTorExitNode     bool
+	IsHostingProvider bool
+	IsPublicProxy     bool
+}
+
+// domain from the (optional) Domain database.
+type domain struct {
+	Domain string
+}
+
+// isp from the (optional) ISP database.
+type isp struct {
+	ISP          string
+	Organization string
+}
+
+// traits from Enterprise databases, e.g. for fraud scoring.
+type traits struct {
+	UserType      string   `json:",omitempty"` // e.g. business, residential, cellular
+	StaticIPScore *float64 `json:",omitempty"` // how static the IP is, from 0 to 99.99
+}
+
+// ipinfo holds the results of the enrichers run for an IP, see enrichers.
+type ipinfo struct {
+	IP          net.IP
+	Hostnames   []string
+	AS          *as          `json:",omitempty"`
+	Location    *location    `json:",omitempty"`
+	ISP         *isp         `json:",omitempty"`
+	Anonymity   *anonymity   `json:",omitempty"`
+	Traits      *traits      `json:",omitempty"`
+	Domain      string       `json:",omitempty"`
+	AddressType string       `json:",omitempty"` // with ?address_type=true
+	Fingerprint string       `json:",omitempty"` // with ?fingerprint=true, see fingerprint
+	Unsupported []string     `json:",omitempty"` // fields whose database doesn't support the IP family
+	Debug       *debugInfo   `json:",omitempty"`
+	Explain     *explanation `json:",omitempty"` // with ?explain=true, see explain
+}
+
+// debugInfo is only included in responses when requested with ?debug=true.
+type debugInfo struct {
+	Sources       map[string]source
+	LookupSeconds map[string]float64  // time spent in the database reads
+	Conflicts     map[string]conflict `json:",omitempty"` // see resolve
+	Providers     map[string]string   // database each result was read from
+	Countries     *countries          `json:",omitempty"` // when they disagree
+	Coverage      map[string]coverage // of the IP by the database each result was read from
+	Country       *countryChoice      `json:",omitempty"` // with -country_resolution=confidence
+}
+
+// coverage is how a database covers an IP: its IP version and record size (in
+// bits), and the network the IP matched, which is Exact for the IP alone
+// rather than a prefix it is aggregated in (whose IPs share coarser data).
+type coverage struct {
+	Database   string
+	IPVersion  uint
+	RecordSize uint
+	Network    string `json:",omitempty"`
+	Exact      bool   `json:",omitempty"`
+}
+
+// coverageOf returns the coverage of ip by db, if loaded.
+func (o options) coverageOf(db *database, ip net.IP) (coverage, bool) {
+	r := o.reader(db)
+	if r == nil {
+		return coverage{}, false
+	}
+	md := r.Metadata()
+	c := coverage{Database: db.name, IPVersion: md.IPVersion, RecordSize: md.RecordSize}
+	var none struct{}
+	if network, ok, err := r.mmdb.LookupNetwork(ip, &none); err == nil && ok {
+		ones, bits := network.Mask.Size()
+		c.Network, c.Exact = network.String(), ones == bits
+	}
+	return c, true
+}
+
+// countries are the codes of the countries an IP is associated with, reported
+// when they disagree, as for disputed regions, for clients to apply their own
+// policy: where its network is registered, the country represented by its
+// users if any, and where it physically is.
+type countries struct {
+	Registered  string
+	Represented string `json:",omitempty"`
+	Physical    string
+}
+
+// disputedCountries returns the countries of loc when they disagree, if ever.
+func disputedCountries(loc *location) *countries {
+	if loc == nil {
+		return nil
+	}
+	c := &countries{Registered: loc.registeredCountry, Physical: loc.CountryCode}
+	if loc.RepresentedCountry != nil {
+		c.Represented = loc.RepresentedCountry.Code
+	}
+	codes := map[string]bool{}
+	for _, code := range []string{c.Registered, c.Represented, c.Physical} {
+		if code != "" {
+			codes[code] = true
+		}
+	}
+	if len(codes) < 2 {
+		return nil
+	}
+	return c
+}
+
+type source struct {
+	File      string
+	Type      string
+	BuildDate time.Time
+}
+
+// getAS looks ip up in the ASN database or, for dated lookups, in its
+// generation at the requested date (leaving the Enterprise database out).
+func getAS(ip string, o options) (as, error) {
+	if !o.date.IsZero() {
+		db, _, err := generation(asnDB, o.date)
+		if err != nil {
+			return as{}, err
+		}
+		return lookupAS(db, ip, o)
+	}
+	data, err := resolve(o, "AS", asnDB, func(db *database) (interface{}, error) {
+		return lookupAS(db, ip, o)
+	})
+	asn, _ := data.(as)
+	return asn, o.override(ip, &asn, err)
+}
+
+func lookupAS(db *database, ip string, o options) (as, error) {
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return as{}, invalidIP(ip)
+	}
+	var data *geoip2.ASN
+	r, err := db.read(o, ip, func(r *dbReader) (err error) {
+		done := o.track("AS", db, ip)
+		data, err = r.asn(ipaddr)
+		done(err)
+		return err
+	})
+	if err != nil {
+		return as{}, err
+	}
+	if !r.contains(ipaddr) {
+		return as{}, errNotFound
+	}
+	// Empty records are no AS data rather than AS0, unless configured otherwise
+	empty := data.AutonomousSystemNumber == 0 && data.AutonomousSystemOrganization == ""
+	if empty && *zeroASN == "not_found" {
+		return as{}, errNotFound
+	}
+	built := r.buildDate()
+	asn := as{
+		Number:      data.AutonomousSystemNumber,
+		Name:        data.AutonomousSystemOrganization,
+		CountryCode: r.asCountry(ipaddr),
+		Incomplete:  !empty && (data.AutonomousSystemNumber == 0 || data.AutonomousSystemOrganization == ""),
+		BuildDate:   &built,
+	}
+	if orgRules != nil {
+		asn.Normalized = normalizeOrg(asn.Name)
+	}
+	if o.asnFormats && asn.Number != 0 {
+		asn.ASPlain, asn.ASDot, asn.Prefixed = asnFormats(asn.Number)
+	}
+	if o.network && !o.redacted["network"] {
+		asn.Network = r.network(ipaddr)
+	}
+	return asn, nil
+}
+
+// asnFormats returns the textual forms of an AS number BGP tools expect, as
+// of RFC 5396: asplain (e.g. "4200000001"), asdot (the same for 2-byte ASNs,
+// "high.low" 16-bit halves for 4-byte ones, e.g. "64086.59905") and prefixed
+// with AS (e.g. "AS4200000001").
+func asnFormats(n uint) (plain, dot, prefixed string) {
+	plain = strconv.FormatUint(uint64(n), 10)
+	dot = plain
+	if n > 0xffff {
+		dot = fmt.Sprintf("%d.%d", n>>16, n&0xffff)
+	}
+	return plain, dot, "AS" + plain
+}
+
+func getAnonymity(ip string, o options) (anonymity, error) {
+	if anonDB == nil {
+		return anonymity{}, errNotConfigured
+	}
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return anonymity{}, invalidIP(ip)
+	}
+	r := o.reader(anonDB)
+	if r == nil {
+		return anonymity{}, errNotLoaded
+	}
+	if err := r.checkFamily(ip); err != nil {
+		return anonymity{}, err
+	}
+	data, err := r.AnonymousIP(ipaddr)
+	if err != nil {
+		return anonymity{}, readerError(err)
+	}
+	if !r.contains(ipaddr) {
+		return anonymity{}, errNotFound
+	}
+	return anonymity{
+		IsAnonymous:       data.IsAnonymous,
+		IsVPN:             data.IsAnonymousVPN,
+		IsTorExitNode:     data.IsTorExitNode,
+		IsHostingProvider: data.IsHostingProvider,
+		IsPublicProxy:     data.IsPublicProxy,
+	}, nil
+}
+
+func getISP(ip string, o options) (isp, error) {
+	if ispDB == nil {
+		return isp{}, errNotConfigured
+	}
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return isp{}, invalidIP(ip)
+	}
+	r := o.reader(ispDB)
+	if r == nil {
+		return isp{}, errNotLoaded
+	}
+	if err := r.checkFamily(ip); err != nil {
+		return isp{}, err
+	}
+	data, err := r.ISP(ipaddr)
+	if err != nil {
+		return isp{}, readerError(err)
+	}
+	if !r.contains(ipaddr) {
+		return isp{}, errNotFound
+	}
+	return isp{ISP: data.ISP, Organization: data.Organization}, nil
+}
+
+// traitsDB returns the Enterprise database, or the GeoIP one if it is an
+// Enterprise database itself; nil if neither is.
+func traitsDB() *database {
+	if enterpriseDB != nil {
+		return enterpriseDB
+	}
+	if r := geoDB.reader(); r != nil && strings.Contains(r.Metadata().DatabaseType, "Enterprise") {
+		return geoDB
+	}
+	return nil
+}
+
+func getTraits(ip string, o options) (traits, error) {
+	db := traitsDB()
+	if db == nil {
+		return traits{}, errNotConfigured
+	}
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return traits{}, invalidIP(ip)
+	}
+	r := o.reader(db)
+	if r == nil {
+		return traits{}, errNotLoaded
+	}
+	if err := r.checkFamily(ip); err != nil {
+		return traits{}, err
+	}
+	// Decoded directly, to tell absent scores apart from null ones
+	var record struct {
+		Traits struct {
+			UserType      string   `maxminddb:"user_type"`
+			StaticIPScore *float64 `maxminddb:"static_ip_score"`
+		} `maxminddb:"traits"`
+	}
+	if err := r.mmdb.Lookup(ipaddr, &record); err != nil {
+		return traits{}, readerError(err)
+	}
+	if record.Traits.UserType == "" && record.Traits.StaticIPScore == nil {
+		return traits{}, errNotFound
+	}
+	return traits{UserType: record.Traits.UserType, StaticIPScore: record.Traits.StaticIPScore}, nil
+}
+
+func getDomain(ip string, o options) (domain, error) {
+	if domainDB == nil {
+		return domain{}, errNotConfigured
+	}
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return domain{}, invalidIP(ip)
+	}
+	r := o.reader(domainDB)
+	if r == nil {
+		return domain{}, errNotLoaded
+	}
+	if err := r.checkFamily(ip); err != nil {
+		return domain{}, err
+	}
+	data, err := r.Domain(ipaddr)
+	if err != nil {
+		return domain{}, readerError(err)
+	}
+	if !r.contains(ipaddr) {
+		return domain{}, errNotFound
+	}
+	return domain{Domain: data.Domain}, nil
+}
+
+// getLocation looks ip up in the GeoIP database or, for dated lookups, in its
+// generation at the requested date (leaving the Enterprise and fallback
+// databases out).
+func getLocation(ip string, o options) (location, error) {
+	if !o.date.IsZero() {
+		db, _, err := generation(geoDB, o.date)
+		if err != nil {
+			return location{}, err
+		}
+		return lookupLocation(db, ip, o)
+	}
+	data, err := resolve(o, "Location", geoDB, func(db *database) (interface{}, error) {
+		return lookupLocation(db, ip, o)
+	})
+	loc, _ := data.(location)
+	// Fallback databases fill in for IPs without (city) data, in order
+	for _, db := range geoFallbacks {
+		if (err == nil && loc.City.String() != "") || (err != nil && !errors.Is(err, errNotFound)) {
+			break
+		}
+		if fallback, fbErr := lookupLocation(db, ip, o); fbErr == nil && (err != nil || fallback.City.String() != "") {
+			loc, err = fallback, nil
+			o.provide("Location", db)
+		}
+	}
+	if err == nil {
+		o.bestCountry(ip, &loc)
+		o.smooth(ip, &loc)
+	}
+	return loc, o.override(ip, &loc, err)
+}
+
+func lookupLocation(db *database, ip string, o options) (location, error) {
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return location{}, invalidIP(ip)
+	}
+	var geo *geoip2.City
+	r, err := db.read(o, ip, func(r *dbReader) (err error) {
+		done := o.track("Location", db, ip)
+		geo, err = r.City(ipaddr)
+		done(err)
+		return err
+	})
+	if err != nil {
+		return location{}, err
+	}
+	if !r.contains(ipaddr) {
+		return location{}, errNotFound
+	}
+	unnamed := unnamedRecord(geo)
+	if unnamed && *unnamedLocations == "not_found" {
+		return location{}, errNotFound
+	}
+	built := r.buildDate()
+	loc := location{
+		Continent:      o.langFor("continent").localize(geo.Continent.Names),
+		ContinentCode:  strings.ToUpper(geo.Continent.Code),
+		Country:        o.langFor("country").localize(geo.Country.Names),
+		CountryCode:    strings.ToUpper(geo.Country.IsoCode),
+		CountryCode3:   alpha3[strings.ToUpper(geo.Country.IsoCode)],
+		City:           o.langFor("city").localize(geo.City.Names),
+		Subdivisions:   make([]subdivision, 0, len(geo.Subdivisions)),
+		AccuracyRadius: geo.Location.AccuracyRadius,
+		TimeZone:       geo.Location.TimeZone,
+		Unnamed:        unnamed,
+		BuildDate:      &built,
+
+		registeredCountry: strings.ToUpper(geo.RegisteredCountry.IsoCode),
+	}
+	if o.localTime {
+		if tz := timeZone(loc.TimeZone); tz != nil {
+			loc.LocalTime = time.Now().In(tz).Format(time.RFC3339)
+		}
+	}
+	// Subdivisions are ordered from the largest to the smallest one, as stored
+	// in the database; the depth limits how many of the largest are kept.
+	for i, sub := range geo.Subdivisions {
+		if o.subdivisionDepth > 0 && i >= o.subdivisionDepth {
+			break
+		}
+		loc.Subdivisions = append(loc.Subdivisions, subdivision{
+			Name: o.langFor("subdivision").localize(sub.Names),
+			Code: sub.IsoCode,
+		})
+	}
+	if o.network {
+		loc.Network = r.network(ipaddr)
+	}
+	loc.IsAnycast = r.isAnycast(ipaddr)
+	if rc := geo.RepresentedCountry; rc.IsoCode != "" && (representedTypes == nil || representedTypes[strings.ToLower(rc.Type)]) {
+		loc.RepresentedCountry = &representedCountry{
+			Name: o.langFor("country").localize(rc.Names),
+			Code: strings.ToUpper(rc.IsoCode),
+			Type: rc.Type,
+		}
+	}
+	// Past the maximum accuracy radius, only keep country-level data
+	if *maxRadius > 0 && int(loc.AccuracyRadius) > *maxRadius {
+		loc.City, loc.Subdivisions, loc.Downgraded = o.langFor("city").localize(nil), loc.Subdivisions[:0], true
+	}
+	// The database has no way to flag absent coordinates other than 0,0
+	if !loc.Downgraded && (geo.Location.Latitude != 0 || geo.Location.Longitude != 0) {
+		loc.Latitude, loc.Longitude = &geo.Location.Latitude, &geo.Location.Longitude
+		loc.roundCoords()
+	} else if o.approximate {
+		approximateCoords(&loc)
+	}
+	if loc.Latitude == nil && *missingCoords == "zero" {
+		lat, long := 0.0, 0.0
+		loc.Latitude, loc.Longitude, loc.placeholder = &lat, &long, true
+	}
+	o.redactLocation(&loc)
+	if o.nearestCity && len(geo.City.Names) == 0 && !o.redacted["city"] {
+		o.setNearestCity(&loc)
+	}
+	if o.geohash > 0 && loc.hasCoords() {
+		loc.Geohash = geohash(*loc.Latitude, *loc.Longitude, o.geohash)
+	}
+	if o.formatted {
+		loc.Formatted = formatLocation(loc)
+	}
+	return loc, nil
+}
+
+// unnamedRecord reports whether geo has no names in any language, as in some
+// minimal databases, rather than only lacking some translations: the fallback
+// languages can't make up for it then.
+func unnamedRecord(geo *geoip2.City) bool {
+	if len(geo.Continent.Names) > 0 || len(geo.Country.Names) > 0 || len(geo.City.Names) > 0 {
+		return false
+	}
+	for _, sub := range geo.Subdivisions {
+		if len(sub.Names) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bigEndianLangs write locations from the country down to the city.
+var bigEndianLangs = map[string]bool{"ja": true, "zh-CN": true}
+
+// formatLocation returns a human-readable form of loc in each of its
+// languages, e.g. "Mountain View, California, United States" or, for
+// languages writing the largest components first (Chinese and Japanese),
+// "United StatesCaliforniaMountain View". Missing (or unknown) components are
+// left out, as well as repeated ones (such as for city-states). Languages are
+// those of the country name, see localized.in for the others.
+func formatLocation(loc location) localized {
+	formatted := make(localized, len(loc.Country))
+	for lang, country := range loc.Country {
+		parts := []string{loc.City.in(lang)}
+		if len(loc.Subdivisions) > 0 {
+			parts = append(parts, loc.Subdivisions[0].Name.in(lang))
+		}
+		parts = append(parts, country)
+
+		sep := ", "
+		if l := relatedLangs[lang]; bigEndianLangs[lang] || bigEndianLangs[l] {
+			sep = ""
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		var kept []string
+		for _, part := range parts {
+			if part != "" && part != *unknownName && (len(kept) == 0 || kept[len(kept)-1] != part) {
+				kept = append(kept, part)
+			}
+		}
+		formatted[lang] = strings.Join(kept, sep)
+	}
+	return formatted
+}
+
+// enrichers are the lookups getIPInfo can run, as selected with ?enrich=.
+var enrichers = []string{"asn", "geo", "isp", "anon", "domain", "traits"}
+
+// timeZones caches the time zones loaded by timeZone, nil for invalid ones.
+var timeZones sync.Map
+
+// timeZone returns the named time zone, or nil if it is missing or invalid.
+func timeZone(name string) *time.Location {
+	if name == "" {
+		return nil
+	}
+	if tz, ok := timeZones.Load(name); ok {
+		return tz.(*time.Location)
+	}
+	tz, err := time.LoadLocation(name)
+	if err != nil {
+		tz = nil
+	}
+	timeZones.Store(name, tz)
+	return tz
+}
+
+// getIPInfo runs the selected enrichers. The optional ones (isp, anon, domain
+// and traits) are left out when their database isn't configured or has no data
+// for ip, while AS and location errors are reported; except when only one of
+// them has no data, as partial data is still worth returning (but not partial
+// data missing a failed lookup, e.g. of a closed reader).
+func getIPInfo(ip string, o options) (ipinfo, error) {
+	ipaddr := net.ParseIP(ip)
+	if ipaddr == nil {
+		return ipinfo{}, invalidIP(ip)
+	}
+	if o.debug {
+		o.timings = make(map[string]float64)
+		o.conflicts = make(map[string]conflict)
+		if o.providers == nil { // shared with the caller when explaining
+			o.providers, o.debugMu = make(map[string]string), new(sync.Mutex)
+		}
+		o.choice = new(countryChoice)
+	}
+	info := ipinfo{IP: ipaddr}
+	if o.addressType {
+		info.AddressType = addressType(ipaddr)
+	}
+	// The lookups are independent, each of them filling in its own fields
+	var mu sync.Mutex
+	unsupported := func(field string, err error) {
+		if errors.Is(err, errUnsupportedFamily) {
+			mu.Lock()
+			info.Unsupported = append(info.Unsupported, field)
+			mu.Unlock()
+		}
+	}
+	var g errgroup.Group
+	g.SetLimit(*lookupConcurrency)
+	if o.hostnames && !o.redacted["hostnames"] {
+		g.Go(func() error {
+			info.Hostnames, _ = lookupPTR(context.Background(), ip)
+			return nil
+		})
+	}
+	var asErr, locErr error
+	if o.enrich["asn"] {
+		g.Go(func() error {
+			var asn as
+			if asn, asErr = getAS(ip, o); asErr == nil {
+				info.AS = &asn
+			}
+			unsupported("AS", asErr)
+			return nil
+		})
+	}
+	if o.enrich["geo"] {
+		g.Go(func() error {
+			var loc location
+			if loc, locErr = getLocation(ip, o); locErr == nil {
+				info.Location = &loc
+			}
+			unsupported("Location", locErr)
+			return nil
+		})
+	}
+	if o.enrich["isp"] {
+		g.Go(func() error {
+			data, err := getISP(ip, o)
+			if err == nil {
+				info.ISP = &data
+			}
+			unsupported("ISP", err)
+			return nil
+		})
+	}
+	if o.enrich["anon"] {
+		g.Go(func() error {
+			data, err := getAnonymity(ip, o)
+			if err == nil {
+				info.Anonymity = &data
+			}
+			unsupported("Anonymity", err)
+			return nil
+		})
+	}
+	if o.enrich["traits"] {
+		g.Go(func() error {
+			data, err := getTraits(ip, o)
+			if err == nil {
+				info.Traits = &data
+			}
+			unsupported("Traits", err)
+			return nil
+		})
+	}
+	if o.enrich["domain"] {
+		g.Go(func() error {
+			data, err := getDomain(ip, o)
+			if err == nil {
+				info.Domain = data.Domain
+			}
+			unsupported("Domain", err)
+			return nil
+		})
+	}
+	g.Wait()
+	sort.Strings(info.Unsupported)
+	// Either of the AS and the location having no data still returns the other
+	// one, while any other error of them fails the lookup
+	var err error
+	switch {
+	case asErr != nil && !errors.Is(asErr, errNotFound):
+		err = asErr
+	case locErr != nil && !errors.Is(locErr, errNotFound):
+		err = locErr
+	case info.AS == nil && info.Location == nil && asErr != nil:
+		err = asErr
+	case info.AS == nil && info.Location == nil:
+		err = locErr
+	}
+	if o.fingerprint {
+		info.Fingerprint = fingerprint(info)
+	}
+	if o.debug {
+		info.Debug = &debugInfo{Sources: make(map[string]source), LookupSeconds: o.timings, Conflicts: o.conflicts, Providers: o.providers, Countries: disputedCountries(info.Location), Coverage: make(map[string]coverage)}
+		if o.choice.Chosen != "" {
+			info.Debug.Country = o.choice
+		}
+		// Sources are keyed by the field their enricher fills in
+		dbs := map[string]*database{"asn": asnDB, "geo": geoDB, "isp": ispDB, "anon": anonDB, "domain": domainDB, "traits": traitsDB()}
+		fields := map[string]string{"asn": "AS", "geo": "Location", "isp": "ISP", "anon": "Anonymity", "domain": "Domain", "traits": "Traits"}
+		for e, db := range dbs {
+			if db != nil && o.enrich[e] {
+				info.Debug.Sources[fields[e]] = db.source()
+				if provider := databaseNamed(o.providers[fields[e]]); provider != nil {
+					db = provider
+				}
+				if c, ok := o.coverageOf(db, ipaddr); ok {
+					info.Debug.Coverage[fields[e]] = c
+				}
+			}
+		}
+		if enterpriseDB != nil && (o.enrich["asn"] || o.enrich["geo"]) {
+			info.Debug.Sources["Enterprise"] = enterpriseDB.source()
+		}
+	}
+	return info, err
+}
+
+// fingerprint hashes the fields of info, sorted by path, for clients to tell
+// whether the result for an IP changed by comparing a single value: it only
+// changes along with one of the fields, however they are ordered. Debug and
+// explain info are left out, as is the local time, as they vary from one
+// request to the other, and hostnames are sorted, PTR records having no order.
+func fingerprint(info ipinfo) string {
+	info.Fingerprint, info.Debug, info.Explain = "", nil, nil
+	if info.Location != nil {
+		loc := *info.Location
+		loc.LocalTime = ""
+		info.Location = &loc
+	}
+	info.Hostnames = append([]string(nil), info.Hostnames...)
+	sort.Strings(info.Hostnames)
+	fields, _ := flatten(info)
+	paths := make([]string, 0, len(fields))
+	for path := range fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s=%q\n", path, fields[path])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
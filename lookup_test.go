@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGetAS(t *testing.T) {
+	tests := []struct {
+		ip     string
+		number uint
+		name   string
+		err    error
+	}{
+		{"8.8.8.8", 15169, "Google LLC", nil},
+		{"1.1.1.1", 13335, "CLOUDFLARENET", nil},
+		{"2001:4860:4860::8888", 15169, "Google LLC", nil},
+		{"10.0.0.1", 0, "", errNotFound},
+		{"192.0.2.1", 0, "", errNotFound},
+		{"::1", 0, "", errNotFound},
+	}
+	for _, tt := range tests {
+		asn, err := getAS(tt.ip, testOptions())
+		if !errors.Is(err, tt.err) {
+			t.Errorf("getAS(%s) error = %v, want %v", tt.ip, err, tt.err)
+		}
+		if asn.Number != tt.number || asn.Name != tt.name {
+			t.Errorf("getAS(%s) = %d %q, want %d %q", tt.ip, asn.Number, asn.Name, tt.number, tt.name)
+		}
+	}
+}
+
+func TestGetASInvalid(t *testing.T) {
+	for _, ip := range []string{"", "notanip", "8.8.8", "8.8.8.8.8", "2001:db8::g"} {
+		if _, err := getAS(ip, testOptions()); !errors.Is(err, errInvalidIP) {
+			t.Errorf("getAS(%q) error = %v, want an invalid ip address", ip, err)
+		}
+	}
+}
+
+func TestGetLocation(t *testing.T) {
+	tests := []struct {
+		ip          string
+		countryCode string
+		city        string
+		err         error
+	}{
+		{"8.8.8.8", "US", "Mountain View", nil},
+		{"1.1.1.1", "AU", "", nil},
+		{"2001:4860:4860::8888", "US", "Mountain View", nil},
+		{"10.0.0.1", "", "", errNotFound},
+		{"172.16.0.1", "", "", errNotFound},
+		{"fd00::1", "", "", errNotFound},
+	}
+	for _, tt := range tests {
+		loc, err := getLocation(tt.ip, testOptions())
+		if !errors.Is(err, tt.err) {
+			t.Errorf("getLocation(%s) error = %v, want %v", tt.ip, err, tt.err)
+		}
+		if loc.CountryCode != tt.countryCode || loc.City.String() != tt.city {
+			t.Errorf("getLocation(%s) = %s %q, want %s %q", tt.ip, loc.CountryCode, loc.City, tt.countryCode, tt.city)
+		}
+	}
+}
+
+func TestGetIPInfo(t *testing.T) {
+	tests := []struct {
+		ip                 string
+		hasAS, hasLocation bool
+		err                error
+	}{
+		{"8.8.8.8", true, true, nil},
+		{"2001:4860:4860::8888", true, true, nil},
+		{"6.6.6.6", false, true, nil}, // location only
+		{"10.0.0.1", false, false, errNotFound},
+	}
+	for _, tt := range tests {
+		info, err := getIPInfo(tt.ip, testOptions())
+		if !errors.Is(err, tt.err) {
+			t.Errorf("getIPInfo(%s) error = %v, want %v", tt.ip, err, tt.err)
+		}
+		if (info.AS != nil) != tt.hasAS || (info.Location != nil) != tt.hasLocation {
+			t.Errorf("getIPInfo(%s) AS = %v, Location = %v, want AS %t, Location %t", tt.ip, info.AS, info.Location, tt.hasAS, tt.hasLocation)
+		}
+	}
+}
+
+func TestGetIPInfoInvalid(t *testing.T) {
+	for _, ip := range []string{"", "notanip", "999.1.1.1"} {
+		if _, err := getIPInfo(ip, testOptions()); !errors.Is(err, errInvalidIP) {
+			t.Errorf("getIPInfo(%q) error = %v, want an invalid ip address", ip, err)
+		}
+	}
+}
+
+func TestClosedReader(t *testing.T) {
+	o := testOptions()
+	o.readers = readerSet{asnDB: closedReader(t, "testdata/asn.mmdb"), geoDB: closedReader(t, "testdata/city.mmdb")}
+	if _, err := getAS("8.8.8.8", o); !errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("getAS error = %v, want %v", err, ErrDatabaseClosed)
+	}
+	if _, err := getLocation("8.8.8.8", o); !errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("getLocation error = %v, want %v", err, ErrDatabaseClosed)
+	}
+	if _, err := getIPInfo("8.8.8.8", o); !errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("getIPInfo error = %v, want %v", err, ErrDatabaseClosed)
+	}
+}
+
+func TestGetIPInfoPartialFailure(t *testing.T) {
+	o := testOptions()
+	o.readers = readerSet{asnDB: closedReader(t, "testdata/asn.mmdb")}
+	if _, err := getIPInfo("8.8.8.8", o); !errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("getIPInfo with a closed ASN reader error = %v, want %v", err, ErrDatabaseClosed)
+	}
+	o.readers = readerSet{geoDB: closedReader(t, "testdata/city.mmdb")}
+	if _, err := getIPInfo("8.8.8.8", o); !errors.Is(err, ErrDatabaseClosed) {
+		t.Errorf("getIPInfo with a closed GeoIP reader error = %v, want %v", err, ErrDatabaseClosed)
+	}
+}
+
+func BenchmarkGetIPInfo(b *testing.B) {
+	defer func(n int) { *lookupConcurrency = n }(*lookupConcurrency)
+	o := testOptions()
+	o.enrich = map[string]bool{"asn": true, "geo": true, "traits": true, "isp": true, "anon": true, "domain": true}
+	for _, n := range []int{1, 4} {
+		*lookupConcurrency = n
+		b.Run(fmt.Sprintf("concurrency=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := getIPInfo("8.8.8.8", o); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnnamedLocation(t *testing.T) {
+	defer func(mode string) { *unnamedLocations = mode }(*unnamedLocations)
+	*unnamedLocations = "flag"
+	loc, err := getLocation("9.9.9.9", testOptions())
+	if err != nil || !loc.Unnamed || loc.CountryCode != "DE" {
+		t.Errorf("getLocation(9.9.9.9) = %+v, %v; want an Unnamed DE location", loc, err)
+	}
+	// Missing translations only aren't unnamed records
+	if loc, err := getLocation("1.1.1.1", testOptions()); err != nil || loc.Unnamed {
+		t.Errorf("getLocation(1.1.1.1) = %+v, %v; want a named location", loc, err)
+	}
+	*unnamedLocations = "not_found"
+	if _, err := getLocation("9.9.9.9", testOptions()); !errors.Is(err, errNotFound) {
+		t.Errorf("getLocation(9.9.9.9) with -unnamed_locations=not_found error = %v, want %v", err, errNotFound)
+	}
+}
+
+func TestUnsupportedFamily(t *testing.T) {
+	withDatabase(t, &asnDB, "asn", "testdata/asn-v4.mmdb")
+	if asn, err := getAS("8.8.8.8", testOptions()); err != nil || asn.Name != "GOOGLE" {
+		t.Errorf("getAS(8.8.8.8) = %q, %v; want GOOGLE", asn.Name, err)
+	}
+	_, err := getAS("2001:4860:4860::8888", testOptions())
+	if !errors.Is(err, errUnsupportedFamily) {
+		t.Fatalf("getAS of an IPv6 address in an IPv4-only database error = %v, want %v", err, errUnsupportedFamily)
+	}
+	if status, body := errorResponse(err); status != 404 || body["code"] != "unsupported_ip_family" {
+		t.Errorf("errorResponse(%v) = %d %v, want 404 unsupported_ip_family", err, status, body["code"])
+	}
+	// The location is still looked up, the AS being flagged as unsupported
+	info, err := getIPInfo("2001:4860:4860::8888", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AS != nil || info.Location == nil || len(info.Unsupported) != 1 || info.Unsupported[0] != "AS" {
+		t.Errorf("getIPInfo = AS %v, location %v, unsupported %v; want only the location, AS unsupported", info.AS, info.Location, info.Unsupported)
+	}
+}
+
+func TestZeroASN(t *testing.T) {
+	defer func(mode string) { *zeroASN = mode }(*zeroASN)
+	*zeroASN = "not_found"
+	if _, err := getAS("9.9.9.9", testOptions()); !errors.Is(err, errNotFound) {
+		t.Errorf("getAS of a zero record error = %v, want %v", err, errNotFound)
+	}
+	// The location is still answered, without any AS
+	if info, err := getIPInfo("9.9.9.9", testOptions()); err != nil || info.AS != nil || info.Location == nil {
+		t.Errorf("getIPInfo of a zero AS record = AS %v, location %v, %v; want only the location", info.AS, info.Location, err)
+	}
+	*zeroASN = "keep"
+	if asn, err := getAS("9.9.9.9", testOptions()); err != nil || asn.Number != 0 || asn.Name != "" || asn.Incomplete {
+		t.Errorf("getAS of a zero record with -zero_asn=keep = %+v, %v; want AS0", asn, err)
+	}
+	// Records with only either of the number and the name are incomplete
+	for _, mode := range []string{"not_found", "keep"} {
+		*zeroASN = mode
+		if asn, err := getAS("4.4.4.4", testOptions()); err != nil || asn.Name != "Unnumbered" || !asn.Incomplete {
+			t.Errorf("getAS of an unnumbered record with -zero_asn=%s = %+v, %v; want it incomplete", mode, asn, err)
+		}
+	}
+}
+
+func TestFingerprintStable(t *testing.T) {
+	o := testOptions()
+	o.fingerprint, o.localTime = true, true
+	first, err := getIPInfo("8.8.8.8", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond) // for the local time to change
+	second, err := getIPInfo("8.8.8.8", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Location.LocalTime == second.Location.LocalTime {
+		t.Fatalf("local time %s unchanged a second later", first.Location.LocalTime)
+	}
+	if first.Fingerprint == "" || first.Fingerprint != second.Fingerprint {
+		t.Errorf("fingerprints a second apart = %q and %q, want the same", first.Fingerprint, second.Fingerprint)
+	}
+	// Nor do the order of the hostnames and the explanation matter
+	info := first
+	info.Hostnames = []string{"b.example.", "a.example."}
+	reordered := info
+	reordered.Hostnames = []string{"a.example.", "b.example."}
+	reordered.Explain = &explanation{Cached: true}
+	if fingerprint(info) != fingerprint(reordered) {
+		t.Error("fingerprint changed with the order of the hostnames or the explanation")
+	}
+	if info.Hostnames[0] != "b.example." {
+		t.Error("fingerprint sorted the hostnames of the result")
+	}
+}
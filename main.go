@@ -3,9 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // the Docker image has no zoneinfo, for local times
 
 	"github.com/gin-gonic/gin"
 	"github.com/oschwald/geoip2-golang"
@@ -13,201 +15,595 @@ import (
 
 const (
 	defaultAddr  = ":8080"
+	defaultMAddr = ""
+	defaultGAddr = ""
+	defaultOTLP  = ""
 	defaultMode  = "release"
 	defaultLang  = "en"
-	defaultAsnDB = "./dbip-asn-lite-2021-06.mmdb"
-	defaultGeoDB = "./dbip-city-lite-2021-06.mmdb"
+	defaultAsnDB = "./asn.mmdb"
+	defaultGeoDB = "./city.mmdb"
+	defaultGeoFB = ""
+	defaultHistD = ""
+	defaultAnoDB = ""
+	defaultDomDB = ""
+	defaultIspDB = ""
+	defaultEntDB = ""
+	defaultOrder = "specific"
+	defaultCmpDB = ""
+	defaultCmpN  = 100
+	defaultProxy = ""
+	defaultTLS   = ""
+	defaultXFF   = "trusted"
+	defaultIPHdr = "CF-Connecting-IP,True-Client-IP,X-Forwarded-For,X-Real-IP"
+	defaultLkHdr = ""
+	defaultCoord = "omit"
+	defaultUnfmt = "reject"
+	defaultRoot  = ""
+	defaultDigit = -1
+	defaultRound = "fixed"
+	defaultMiss  = "fail"
+	defaultLogFm = "default"
+	defaultAudit = ""
+	defaultAnonA = false
+	defaultAnonT = true
+	defaultCheck = 0 * time.Second
+	defaultPprof = false
+	defaultDrain = 5 * time.Second
+	defaultStop  = 10 * time.Second
+	defaultFails = 3
+	defaultRetry = 1
+	defaultToken = ""
+	defaultKeys  = ""
+	defaultEgURL = "https://api.ipify.org"
+	defaultMaxAR = 0
+	defaultRedac = ""
+	defaultSnap  = ""
+	defaultOverr = ""
+	defaultDNS   = ""
+	defaultDNSTO = 2 * time.Second
+	defaultResIP = 10
+	defaultCache = 0 * time.Second
+	defaultCoals = false
+	defaultRedis = ""
+	defaultBulkN = 1000
+	defaultMaxRS = 0
+	defaultWarmU = ""
+	defaultJSONE = "stdlib"
+	defaultV4Map = true
+	defaultStage = "1.1.1.1,8.8.8.8,2001:4860:4860::8888"
+	defaultDedup = true
+	defaultBulkC = "snapshot"
+	defaultOrgNo = ""
+	defaultPTRN  = 16
+	defaultPTRTO = 5 * time.Second
+	defaultConcy = 4
+	defaultIPLen = 45 // ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255
+	defaultHosts = 65536
+	defaultSampl = "off"
+	defaultUnkwn = ""
+	defaultUnnam = "flag"
+	defaultZeroA = "not_found"
+	defaultSmoot = "off"
+	defaultCtryR = "off"
+	defaultCtryP = ""
+	defaultRepTy = ""
+	defaultRelat = "zh-TW:zh-CN,zh-HK:zh-CN,pt-PT:pt-BR"
+	defaultHints = "AR:es,AT:de,BR:pt-BR,CH:de,CL:es,CN:zh-CN,CO:es,DE:de,ES:es,FR:fr,JP:ja,MX:es,PE:es,PT:pt-BR,RU:ru"
 )
 
 var (
-	addr, asnDB, geoDB, lang *string
-	asnReader, locReader     *geoip2.Reader
+	addr, metricsAddr, asnFile, geoFile, lang *string
+	grpcAddr, otlpEndpoint                    *string
+	anonFile, cmpAsnFile, cmpGeoFile          *string
+	domainFile, ispFile, enterpriseFile       *string
+	precedence                                *string
+	trustedProxies, ipHeaders, missingCoords  *string
+	forwardedFor, unsupportedFormat, rootKey  *string
+	lookupIPHeader                            *string
+	reloadToken, egressURL, stageProbeIPs     *string
+	tlsCert, tlsKey                           *string
+	snapshotFile, snapshotGen, logFormat      *string
+	unknownName, unnamedLocations             *string
+	geoSmoothing, zeroASN, countryResolution  *string
+	checkEvery, dnsTimeout, reloadDrain       *time.Duration
+	shutdownTimeout                           *time.Duration
+	checkFails, maxRadius, maxCompare         *int
+	maxBulk, coordPrecision, maxRangeHosts    *int
+	maxIPLength, lookupConcurrency            *int
+	maxResponseSize                           *int
+	maxResolved, readRetries                  *int
+	bulkPTRConcurrency                        *int
+	bulkPTRTimeout                            *time.Duration
+	rangeSampling, coordRounding              *string
+	bulkConsistency, jsonEncoding             *string
+	bulkDedup, profiler, coalesceLookups      *bool
+	normalizeV4Mapped, traceAnonymize         *bool
+	asnDB, geoDB, anonDB                      *database
+	domainDB, ispDB, enterpriseDB             *database
+	cmpAsnDB, cmpGeoDB                        *database
+	geoFallbacks                              []*database
 )
 
-type as struct {
-	Number uint
-	Name   string
-}
+// setup configures the service and loads its databases, from the command line
+// and environment variables; run by main, and by tests before anything else.
+func setup() {
+	// Parse arguments, defaulting to environment variables
+	addr = flag.String("a", getenv("IPINFO_ADDR", defaultAddr), "Listening address:port")
+	tlsCert = flag.String("tls_cert", getenv("IPINFO_TLS_CERT", defaultTLS), "PEM certificate file, to serve HTTPS along with -tls_key")
+	tlsKey = flag.String("tls_key", getenv("IPINFO_TLS_KEY", defaultTLS), "PEM private key file of -tls_cert")
+	metricsAddr = flag.String("metrics_a", getenv("IPINFO_METRICS_ADDR", defaultMAddr), "Listening address:port for /metrics and the /healthz, /livez and /readyz endpoints (defaults to the main listener)")
+	grpcAddr = flag.String("grpc_a", getenv("IPINFO_GRPC_ADDR", defaultGAddr), "Listening address:port of the gRPC server (disabled by default)")
+	otlpEndpoint = flag.String("otlp_endpoint", getenv("IPINFO_OTLP_ENDPOINT", defaultOTLP), "OTLP/HTTP collector (host:port) traces are exported to (disabled by default)")
+	traceAnonymize = flag.Bool("trace_anonymize", getenvBool("IPINFO_TRACE_ANONYMIZE", defaultAnonT), "Truncate the IPs of the trace span attributes to their /24 (IPv4) or /48 (IPv6) network")
+	mode := flag.String("m", getenv("IPINFO_MODE", defaultMode), "Gin mode (available modes: debug, test, release)")
+	logFormat = flag.String("log_format", getenv("IPINFO_LOG_FORMAT", defaultLogFm), "Access log format (available formats: default, json, w3c)")
+	auditFile := flag.String("audit_log", getenv("IPINFO_AUDIT_LOG", defaultAudit), "File lookups are logged to as JSON lines, for auditing (- for stdout, disabled by default)")
+	auditAnon := flag.Bool("audit_anonymize", getenvBool("IPINFO_AUDIT_ANONYMIZE", defaultAnonA), "Truncate the IPs of the audit log to their /24 (IPv4) or /48 (IPv6) network")
+	asnFile = flag.String("db_asn", getenv("IPINFO_DB_ASN", defaultAsnDB), "ASN mmdb file")
+	geoFile = flag.String("db_geoip", getenv("IPINFO_DB_GEOIP", defaultGeoDB), "GeoIP mmdb file")
+	historyFiles := flag.String("db_history", getenv("IPINFO_DB_HISTORY", defaultHistD), "Comma-separated older ASN or GeoIP mmdb files, which lookups with ?date= are served from (optional)")
+	geoFallbackFiles := flag.String("db_geoip_fallback", getenv("IPINFO_DB_GEOIP_FALLBACK", defaultGeoFB), "Comma-separated GeoIP mmdb files tried in order when the GeoIP database has no (city) data for an IP (optional)")
+	warmupFile := flag.String("warmup_file", getenv("IPINFO_WARMUP_FILE", defaultWarmU), "File of representative IPs, one per line, looked up in every database as it is loaded to fault in the pages lookups touch (optional)")
+	warmupList := flag.String("warmup_ips", getenv("IPINFO_WARMUP_IPS", defaultWarmU), "Comma-separated representative IPs, looked up along with those of -warmup_file (optional)")
+	missing := flag.String("db_missing", getenv("IPINFO_DB_MISSING", defaultMiss), "What to do when a database file is missing at startup (available modes: fail, warn, wait)")
+	anonFile = flag.String("db_anon", getenv("IPINFO_DB_ANON", defaultAnoDB), "Anonymous IP mmdb file (optional)")
+	domainFile = flag.String("db_domain", getenv("IPINFO_DB_DOMAIN", defaultDomDB), "Domain mmdb file (optional)")
+	ispFile = flag.String("db_isp", getenv("IPINFO_DB_ISP", defaultIspDB), "ISP mmdb file (optional)")
+	enterpriseFile = flag.String("db_enterprise", getenv("IPINFO_DB_ENTERPRISE", defaultEntDB), "Combined Enterprise mmdb file, providing both ASN and GeoIP data (optional)")
+	precedence = flag.String("db_precedence", getenv("IPINFO_DB_PRECEDENCE", defaultOrder), "Which of the ASN/GeoIP and Enterprise databases wins when both have data for an IP, the other one filling in otherwise (available orders: specific, enterprise)")
+	cmpAsnFile = flag.String("compare_asn", getenv("IPINFO_COMPARE_DB_ASN", defaultCmpDB), "Secondary ASN mmdb file compared against by /compare (optional)")
+	cmpGeoFile = flag.String("compare_geoip", getenv("IPINFO_COMPARE_DB_GEOIP", defaultCmpDB), "Secondary GeoIP mmdb file compared against by /compare (optional)")
+	maxCompare = flag.Int("compare_max", getenvInt("IPINFO_COMPARE_MAX", defaultCmpN), "Maximum number of IPs compared by a /compare request")
+	lookupConcurrency = flag.Int("lookup_concurrency", getenvInt("IPINFO_LOOKUP_CONCURRENCY", defaultConcy), "Maximum number of concurrent database and DNS lookups of an /ipinfo request (1 runs them serially)")
+	bulkDedup = flag.Bool("bulk_dedup", getenvBool("IPINFO_BULK_DEDUP", defaultDedup), "Look up repeated IPs of bulk requests only once (overridden per request with ?dedup=)")
+	bulkPTRConcurrency = flag.Int("bulk_ptr_concurrency", getenvInt("IPINFO_BULK_PTR_CONCURRENCY", defaultPTRN), "Reverse DNS lookups run at once by /bulk requests with ?ptr=true")
+	bulkPTRTimeout = flag.Duration("bulk_ptr_timeout", getenvDuration("IPINFO_BULK_PTR_TIMEOUT", defaultPTRTO), "Time the reverse DNS lookups of a /bulk request with ?ptr=true are given overall")
+	bulkConsistency = flag.String("bulk_consistency", getenv("IPINFO_BULK_CONSISTENCY", defaultBulkC), "Databases the IPs of bulk requests are looked up in when reloaded midway (available modes: snapshot of the ones at the start of the request, latest ones)")
+	maxBulk = flag.Int("bulk_max", getenvInt("IPINFO_BULK_MAX", defaultBulkN), "Maximum number of IPs looked up by a /bulk request")
+	maxResponseSize = flag.Int("max_response_size", getenvInt("IPINFO_MAX_RESPONSE_SIZE", defaultMaxRS), "Size (bytes) past which /bulk responses are rejected and /admin/export streams are cut off with an error line (0 disables it)")
+	normalizeV4Mapped = flag.Bool("normalize_v4_mapped", getenvBool("IPINFO_NORMALIZE_V4_MAPPED", defaultV4Map), "Look up and echo IPv4-mapped (::ffff:a.b.c.d) and IPv4-compatible (::a.b.c.d) addresses as the IPv4 ones they stand for, rather than as IPv6 ones")
+	jsonEncoding = flag.String("json_encoder", getenv("IPINFO_JSON_ENCODER", defaultJSONE), "JSON encoder of responses (available encoders: stdlib, jsoniter for throughput)")
+	maxIPLength = flag.Int("max_ip_length", getenvInt("IPINFO_MAX_IP_LENGTH", defaultIPLen), "Length past which :ip path parameters are rejected without being parsed")
+	maxRangeHosts = flag.Int("range_max", getenvInt("IPINFO_MAX_CIDR_HOSTS", defaultHosts), "Maximum number of IPs looked up by a /range request (or sampled, past it)")
+	rangeSampling = flag.String("range_sampling", getenv("IPINFO_CIDR_SAMPLING", defaultSampl), "How /range handles larger ranges (available strategies: off to refuse them, endpoints of their prefixes, random sample of range_max IPs)")
+	lang = flag.String("l", getenv("IPINFO_LANG", defaultLang), "Language used for names (available languages: de, en, es, fr, ja, pt-BR, ru, zh-CN, or their -related_langs variants)")
+	trustedProxies = flag.String("trusted_proxies", getenv("IPINFO_TRUSTED_PROXIES", defaultProxy), "Comma-separated IPs/CIDRs of proxies allowed to set client IP headers")
+	forwardedFor = flag.String("forwarded_for", getenv("IPINFO_FORWARDED_FOR", defaultXFF), "How the client is found in client IP header chains (available modes: trusted for the rightmost untrusted address, first for the leftmost one)")
+	ipHeaders = flag.String("ip_headers", getenv("IPINFO_IP_HEADERS", defaultIPHdr), "Comma-separated client IP headers, by order of precedence (only honored from trusted proxies)")
+	lookupIPHeader = flag.String("lookup_ip_header", getenv("IPINFO_LOOKUP_IP_HEADER", defaultLkHdr), "Header lookups take the IP from when the path has no valid one, e.g. X-Lookup-IP for /ipinfo/- (disabled without)")
+	unsupportedFormat = flag.String("unsupported_format", getenv("IPINFO_UNSUPPORTED_FORMAT", defaultUnfmt), "How requests for unsupported formats are answered (available modes: reject with a 406, json to fall back to JSON)")
+	rootKey = flag.String("root_key", getenv("IPINFO_ROOT_KEY", defaultRoot), "Key successful JSON responses are nested under, e.g. result (bare objects without)")
+	missingCoords = flag.String("missing_coords", getenv("IPINFO_MISSING_COORDS", defaultCoord), "How absent coordinates are rendered (available modes: omit, null, zero)")
+	coordPrecision = flag.Int("coord_precision", getenvInt("IPINFO_COORD_PRECISION", defaultDigit), "Decimal places coordinates are rounded to, e.g. 3 for ~100m (-1 disables it)")
+	coordRounding = flag.String("coord_rounding", getenv("IPINFO_COORD_ROUNDING", defaultRound), "How coordinates are rounded (available modes: fixed to coord_precision, accuracy to match their accuracy radius, within coord_precision)")
+	redact := flag.String("redact", getenv("IPINFO_REDACT", defaultRedac), "Comma-separated fields redacted from every response (available fields: "+strings.Join(redactable, ", ")+")")
+	redactTokens := flag.String("redact_tokens", getenv("IPINFO_REDACT_TOKENS", defaultRedac), "Comma-separated token:field|field pairs of fields also redacted for requests with that bearer token")
+	maxRadius = flag.Int("max_accuracy_radius", getenvInt("IPINFO_MAX_ACCURACY_RADIUS", defaultMaxAR), "Accuracy radius (km) past which city-level data is dropped (0 disables it)")
+	dnsServer := flag.String("dns", getenv("IPINFO_DNS_SERVER", defaultDNS), "DNS server (host[:port]) used instead of the system resolver")
+	dnsTimeout = flag.Duration("dns_timeout", getenvDuration("IPINFO_DNS_TIMEOUT", defaultDNSTO), "Timeout of DNS lookups")
+	maxResolved = flag.Int("dns_max_ips", getenvInt("IPINFO_MAX_RESOLVED_IPS", defaultResIP), "Maximum number of IPs a hostname resolves to which get looked up, the others being left out (0 disables it)")
+	coalesceLookups = flag.Bool("coalesce_lookups", getenvBool("IPINFO_COALESCE_LOOKUPS", defaultCoals), "Run concurrent identical lookups missing the cache only once, sharing their result")
+	cacheTTL := flag.Duration("cache_ttl", getenvDuration("IPINFO_CACHE_TTL", defaultCache), "How long lookup results are cached (0 disables caching)")
+	redisAddr := flag.String("redis", getenv("IPINFO_REDIS_ADDR", defaultRedis), "Redis server (host:port) shared by instances to cache results, instead of memory")
+	readRetries = flag.Int("read_retries", getenvInt("IPINFO_READ_RETRIES", defaultRetry), "Retries of failed ASN and GeoIP database reads, which can fail transiently while files are swapped (0 disables them)")
+	checkEvery = flag.Duration("selfcheck", getenvDuration("IPINFO_SELFCHECK", defaultCheck), "Interval between database self-checks (0 disables them)")
+	checkFails = flag.Int("selfcheck_failures", getenvInt("IPINFO_SELFCHECK_FAILURES", defaultFails), "Consecutive failed self-checks before reloading a database")
+	reloadDrain = flag.Duration("reload_drain", getenvDuration("IPINFO_RELOAD_DRAIN", defaultDrain), "How long replaced readers are kept open after a reload, for in-flight lookups to complete")
+	shutdownTimeout = flag.Duration("shutdown_timeout", getenvDuration("IPINFO_SHUTDOWN_TIMEOUT", defaultStop), "How long in-flight requests are given to complete on SIGINT or SIGTERM, before every listener is closed")
+	stageProbeIPs = flag.String("stage_probe_ips", getenv("IPINFO_STAGE_PROBE_IPS", defaultStage), "Comma-separated IPs looked up by /admin/stage dry runs in a candidate database and the live one")
+	reloadToken = flag.String("reload_token", getenv("IPINFO_RELOAD_TOKEN", defaultToken), "Bearer token required by reload (and enabling admin) endpoints")
+	keys := flag.String("api_keys", getenv("IPINFO_API_KEYS", defaultKeys), "Comma-separated name:key pairs of the API keys required in X-API-Key headers")
+	keysFile := flag.String("api_keys_file", getenv("IPINFO_API_KEYS_FILE", defaultKeys), "File of name:key API keys, one per line, in addition to -api_keys")
+	profiler = flag.Bool("pprof", getenvBool("IPINFO_PPROF", defaultPprof), "Serve the admin-only /debug/pprof profiler endpoints, on the -metrics_a listener if set")
+	egressURL = flag.String("egress_url", getenv("IPINFO_EGRESS_URL", defaultEgURL), "HTTP service echoing the caller's IP, used by /server/ip")
+	snapshotFile = flag.String("snapshot", getenv("IPINFO_SNAPSHOT", defaultSnap), "JSON snapshot of precomputed lookups, served before querying databases")
+	overridesFile := flag.String("overrides", getenv("IPINFO_OVERRIDES", defaultOverr), "JSON file of network:fields overrides patching the AS and location of IPs known to be wrong in the databases")
+	snapshotGen = flag.String("snapshot_gen", "", "Generate a snapshot for the IPs listed in this file (- for stdin) on stdout, then exit")
+	unknownName = flag.String("unknown_placeholder", getenv("IPINFO_UNKNOWN_PLACEHOLDER", defaultUnkwn), "Name of continents, countries, cities and subdivisions not named in any fallback language (empty by default)")
+	geoSmoothing = flag.String("geo_smoothing", getenv("IPINFO_GEO_SMOOTHING", defaultSmoot), "How coordinates are picked when several city databases (GeoIP, Enterprise and fallbacks) have some for an IP in the same country (available modes: off for the first one, centroid of them all, confident for the most accurate)")
+	countryResolution = flag.String("country_resolution", getenv("IPINFO_COUNTRY_RESOLUTION", defaultCtryR), "How the country is picked when city databases (GeoIP, Enterprise and fallbacks) disagree on it (available modes: off for the first one with data, confidence for the highest combined Enterprise confidence, ties going to -country_priority)")
+	priority := flag.String("country_priority", getenv("IPINFO_COUNTRY_PRIORITY", defaultCtryP), "Comma-separated names of the city databases (geoip, enterprise, geoip-fallback-N) in the order they win country ties with -country_resolution=confidence, the others following in lookup order")
+	zeroASN = flag.String("zero_asn", getenv("IPINFO_ZERO_ASN", defaultZeroA), "How AS records with neither a number nor a name are answered (available modes: not_found as no AS data, keep for AS0)")
+	unnamedLocations = flag.String("unnamed_locations", getenv("IPINFO_UNNAMED_LOCATIONS", defaultUnnam), "How locations the database has no names at all for are answered (available modes: flag them as Unnamed, not_found for a 404)")
+	related := flag.String("related_langs", getenv("IPINFO_RELATED_LANGS", defaultRelat), "Comma-separated variant:language pairs of language variants whose missing names are taken from a related language, before English")
+	repTypes := flag.String("represented_types", getenv("IPINFO_REPRESENTED_TYPES", defaultRepTy), "Comma-separated types of the represented countries included in locations, e.g. military (all of them by default)")
+	orgNorm := flag.String("org_normalization", getenv("IPINFO_ORG_NORMALIZATION", defaultOrgNo), "Comma-separated rules AS organization names are also returned normalized with (available rules: "+strings.Join(orgNormalizations, ", ")+"; disabled by default)")
+	hints := flag.String("hint_langs", getenv("IPINFO_HINT_LANGS", defaultHints), "Comma-separated country:language pairs used to derive the language from ?geo_hint=")
+	flag.Parse()
 
-type location struct {
-	Continent     string
-	ContinentCode string
-	Country       string
-	CountryCode   string
-	City          string
-}
+	relatedLangs = parseRelatedLangs(*related)
+	if !supportedLang(*lang) {
+		// Fallback to English
+		*lang = "en"
+	}
 
-type ipinfo struct {
-	IP        net.IP
-	Hostnames []string
-	AS        as
-	Location  location
-}
+	hintLangs = parseHintLangs(*hints)
+	orgRules = parseOrgRules(*orgNorm)
+	for _, t := range splitList(*repTypes) {
+		if representedTypes == nil {
+			representedTypes = make(map[string]bool)
+		}
+		representedTypes[strings.ToLower(t)] = true
+	}
+	redactFields = parseRedactFields(*redact, ",")
+	redactByToken = parseRedactTokens(*redactTokens)
+	resolver = newResolver(*dnsServer)
+	if *cacheTTL > 0 && *redisAddr != "" {
+		resultCache = newRedisCache(*redisAddr, *cacheTTL)
+	} else if *cacheTTL > 0 {
+		resultCache = newMemoryCache(*cacheTTL)
+	}
 
-func init() {
-	// Lookup environment variables
-	var a, m, aDB, gDB, l string
-	if a = os.Getenv("IPINFO_ADDR"); a == "" {
-		a = defaultAddr
+	switch *missingCoords {
+	case "omit", "null", "zero":
+	default:
+		*missingCoords = defaultCoord
 	}
-	if m = os.Getenv("IPINFO_MODE"); m == "" {
-		m = defaultMode
+	if *lookupConcurrency < 1 {
+		*lookupConcurrency = 1
 	}
-	if aDB = os.Getenv("IPINFO_DB_ASN"); aDB == "" {
-		aDB = defaultAsnDB
+	if *bulkPTRConcurrency < 1 {
+		*bulkPTRConcurrency = 1
 	}
-	if gDB = os.Getenv("IPINFO_DB_GEOIP"); gDB == "" {
-		gDB = defaultGeoDB
+	switch *unsupportedFormat {
+	case "reject", "json":
+	default:
+		*unsupportedFormat = defaultUnfmt
 	}
-	if l = os.Getenv("IPINFO_LANG"); l == "" {
-		l = defaultLang
+	switch *forwardedFor {
+	case "trusted", "first":
+	default:
+		*forwardedFor = defaultXFF
 	}
-
-	// Parse arguments
-	addr = flag.String("a", a, "Listening address:port")
-	mode := flag.String("m", m, "Gin mode (available modes: debug, test, release)")
-	asnDB = flag.String("db_asn", aDB, "ASN mmdb file")
-	geoDB = flag.String("db_geoip", gDB, "GeoIP mmdb file")
-	lang = flag.String("l", l, "Language used for names (available languages: de, en, es, fr, ja, pt-BR, ru, zh-CN)")
-	flag.Parse()
-
-	switch *lang {
-	case "en", "de", "es", "fr", "ja", "pt-BR", "ru", "zh-CN":
+	switch *coordRounding {
+	case "fixed", "accuracy":
 	default:
-		// Fallback to English
-		*lang = "en"
+		*coordRounding = defaultRound
+	}
+	switch *rangeSampling {
+	case "off", "endpoints", "random":
+	default:
+		*rangeSampling = defaultSampl
+	}
+	switch *geoSmoothing {
+	case "off", "centroid", "confident":
+	default:
+		*geoSmoothing = defaultSmoot
+	}
+	switch *countryResolution {
+	case "off", "confidence":
+	default:
+		*countryResolution = defaultCtryR
+	}
+	countryPriority = splitList(*priority)
+	switch *zeroASN {
+	case "not_found", "keep":
+	default:
+		*zeroASN = defaultZeroA
+	}
+	switch *unnamedLocations {
+	case "flag", "not_found":
+	default:
+		*unnamedLocations = defaultUnnam
+	}
+	switch *jsonEncoding {
+	case "stdlib", "jsoniter":
+	default:
+		*jsonEncoding = defaultJSONE
+	}
+	switch *bulkConsistency {
+	case "snapshot", "latest":
+	default:
+		*bulkConsistency = defaultBulkC
+	}
+	switch *precedence {
+	case "specific", "enterprise":
+	default:
+		*precedence = defaultOrder
+	}
+	switch *missing {
+	case "fail", "warn", "wait":
+	default:
+		*missing = defaultMiss
 	}
 
 	// Set Gin mode
 	gin.SetMode(*mode)
 
 	// Load databases
+	asnDB = newDatabase("asn", asnFile, "-db_asn or IPINFO_DB_ASN", func(r *geoip2.Reader) error {
+		_, err := r.ASN(probeIP)
+		return err
+	})
+	geoDB = newDatabase("geoip", geoFile, "-db_geoip or IPINFO_DB_GEOIP", func(r *geoip2.Reader) error {
+		_, err := r.City(probeIP)
+		return err
+	})
+	for i, file := range splitList(*geoFallbackFiles) {
+		file := file
+		geoFallbacks = append(geoFallbacks, newDatabase(fmt.Sprintf("geoip-fallback-%d", i+1), &file, "-db_geoip_fallback or IPINFO_DB_GEOIP_FALLBACK", geoDB.probe))
+	}
+	for i, file := range splitList(*historyFiles) {
+		file := file
+		historyDBs = append(historyDBs, newDatabase(fmt.Sprintf("history-%d", i+1), &file, "-db_history or IPINFO_DB_HISTORY", probeHistory))
+	}
+	if *cmpAsnFile != "" {
+		cmpAsnDB = newDatabase("asn-compare", cmpAsnFile, "-compare_asn or IPINFO_COMPARE_DB_ASN", asnDB.probe)
+	}
+	if *cmpGeoFile != "" {
+		cmpGeoDB = newDatabase("geoip-compare", cmpGeoFile, "-compare_geoip or IPINFO_COMPARE_DB_GEOIP", geoDB.probe)
+	}
+	if *anonFile != "" {
+		anonDB = newDatabase("anon", anonFile, "-db_anon or IPINFO_DB_ANON", func(r *geoip2.Reader) error {
+			_, err := r.AnonymousIP(probeIP)
+			return err
+		})
+	}
+	if *domainFile != "" {
+		domainDB = newDatabase("domain", domainFile, "-db_domain or IPINFO_DB_DOMAIN", func(r *geoip2.Reader) error {
+			_, err := r.Domain(probeIP)
+			return err
+		})
+	}
+	if *ispFile != "" {
+		ispDB = newDatabase("isp", ispFile, "-db_isp or IPINFO_DB_ISP", func(r *geoip2.Reader) error {
+			_, err := r.ISP(probeIP)
+			return err
+		})
+	}
+	if *enterpriseFile != "" {
+		enterpriseDB = newDatabase("enterprise", enterpriseFile, "-db_enterprise or IPINFO_DB_ENTERPRISE", func(r *geoip2.Reader) error {
+			_, err := r.Enterprise(probeIP)
+			return err
+		})
+	}
 	var err error
-	asnReader, err = loadDB(*asnDB)
-	if err != nil {
+	if apiKeys, err = loadAPIKeys(*keys, *keysFile); err != nil {
 		panic(err)
 	}
-	locReader, err = loadDB(*geoDB)
-	if err != nil {
+	if warmupIPs, err = loadWarmupIPs(*warmupFile, *warmupList); err != nil {
 		panic(err)
 	}
+	if *auditFile != "" {
+		if audit, err = openAuditLog(*auditFile, *auditAnon); err != nil {
+			panic(err)
+		}
+	}
+	loadDatabases(*missing)
+	if *overridesFile != "" {
+		if overrides, err = loadOverrides(*overridesFile); err != nil {
+			panic(err)
+		}
+	}
+	if *snapshotFile != "" {
+		if snap, err = loadSnapshot(*snapshotFile); err != nil {
+			panic(err)
+		}
+	}
 }
 
-func loadDB(file string) (*geoip2.Reader, error) {
-	return geoip2.Open(file)
-}
+func main() {
+	setup()
 
-func unloadDB(db *geoip2.Reader) {
-	db.Close()
-}
+	// Snapshot generation mode
+	if *snapshotGen != "" {
+		in := os.Stdin
+		if *snapshotGen != "-" {
+			f, err := os.Open(*snapshotGen)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		if err := generateSnapshot(in, os.Stdout); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-func main() {
 	// Setup router
-	r := gin.Default()
+	r := gin.New()
+	r.Use(resolveClient, accessLogger(*logFormat), gin.Recovery(), instrument)
+	if *otlpEndpoint != "" {
+		if err := setupTracing(*otlpEndpoint); err != nil {
+			panic(err)
+		}
+		r.Use(traceRequest)
+	}
 
-	// ASN
-	r.GET("/asn/reload", func(c *gin.Context) {
-		newReader, err := loadDB(*asnDB)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   err.Error(),
-				"message": "failed to load database; using previous one...",
-			})
-		} else {
-			unloadDB(asnReader)
-			asnReader = newReader
-			c.JSON(http.StatusOK, gin.H{"message": "asn database reloaded successfully"})
+	// Client IP resolution (used by /myip and /whoami)
+	r.TrustedProxies = splitList(*trustedProxies)
+	r.RemoteIPHeaders = splitList(*ipHeaders)
+	proxyNets, clientIPHeaders = parseProxies(*trustedProxies), r.RemoteIPHeaders
+
+	var l listeners
+	l.add(*addr, r, *tlsCert != "" && *tlsKey != "")
+
+	// Observability endpoints, optionally kept off the public listener
+	// (along with the profiler, when enabled)
+	if *metricsAddr == "" {
+		registerObservability(r)
+		if *profiler {
+			registerProfiler(r)
+		}
+	} else {
+		m := gin.New()
+		m.Use(gin.Recovery())
+		registerObservability(m)
+		if *profiler {
+			registerProfiler(m)
 		}
+		l.add(*metricsAddr, m, false)
+	}
+
+	// Every other endpoint requires an API key, when configured
+	if len(apiKeys) > 0 {
+		r.Use(requireAPIKey)
+	}
+
+	// Loaded databases
+	r.GET("/db/info", func(c *gin.Context) {
+		c.JSON(http.StatusOK, databasesInfo())
 	})
 
-	r.GET("/asn/:ip", func(c *gin.Context) {
-		if asn, err := getAS(c.Param("ip")); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusOK, asn)
-		}
+	// ASN
+	r.GET("/asn/reload", requireToken(false), reloadHandler(asnDB))
+
+	lookupRoute(r, "/asn/:ip", func(c *gin.Context) {
+		lookup(c, "asn", targetIP(c, c.Param("ip")))
 	})
 
 	// GeoIP data
-	r.GET("/geo/reload", func(c *gin.Context) {
-		newReader, err := loadDB(*geoDB)
+	r.GET("/geo/reload", requireToken(false), reloadHandler(geoDB))
+
+	lookupRoute(r, "/geo/:ip", func(c *gin.Context) {
+		lookup(c, "geo", targetIP(c, c.Param("ip")))
+	})
+
+	// Anonymous IP (optional)
+	if anonDB != nil {
+		r.GET("/anon/reload", requireToken(false), reloadHandler(anonDB))
+	}
+
+	lookupRoute(r, "/anon/:ip", func(c *gin.Context) {
+		lookup(c, "anon", targetIP(c, c.Param("ip")))
+	})
+
+	// Domain (optional)
+	if domainDB != nil {
+		r.GET("/domain/reload", requireToken(false), reloadHandler(domainDB))
+	}
+
+	lookupRoute(r, "/domain/:ip", func(c *gin.Context) {
+		lookup(c, "domain", targetIP(c, c.Param("ip")))
+	})
+
+	// ISP (optional, only available through enrichers)
+	if ispDB != nil {
+		r.GET("/isp/reload", requireToken(false), reloadHandler(ispDB))
+	}
+
+	// IP Info (ASN + GeoIP combined, by default)
+	lookupRoute(r, "/ipinfo/:ip", func(c *gin.Context) {
+		lookup(c, "all", targetIP(c, c.Param("ip")))
+	})
+
+	// Any of the above, with the IP given in the query string
+	lookupRoute(r, "/lookup", func(c *gin.Context) {
+		lookup(c, c.DefaultQuery("type", "all"), targetIP(c, c.Query("ip")))
+	})
+
+	// IP parsing only, always 200 (with Valid false for malformed input)
+	lookupRoute(r, "/validate/:ip", func(c *gin.Context) {
+		render(c, requestOptions(c), http.StatusOK, validate(c.Param("ip")))
+	})
+
+	// Bulk lookups of a JSON array of IPs
+	r.POST("/bulk", limitResponse, bulk)
+
+	// Lookups of every IP in a CIDR block or start-end range
+	lookupRoute(r, "/range", lookupRange)
+
+	// DNS
+	lookupRoute(r, "/ptr/:ip", func(c *gin.Context) {
+		lookup(c, "ptr", targetIP(c, c.Param("ip")))
+	})
+
+	lookupRoute(r, "/lookup/:host", func(c *gin.Context) {
+		lookup(c, "host", c.Param("host"))
+	})
+
+	// Hosts of URLs or email addresses, e.g. from abuse reports
+	lookupRoute(r, "/resolve", func(c *gin.Context) {
+		host, err := extractHost(c.Query("q"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   err.Error(),
-				"message": "failed to load database; using previous one...",
-			})
-		} else {
-			unloadDB(locReader)
-			locReader = newReader
-			c.JSON(http.StatusOK, gin.H{"message": "geoip database reloaded successfully"})
+			render(c, requestOptions(c), http.StatusBadRequest, gin.H{"error": err.Error(), "code": "bad_request"})
+			return
 		}
+		lookup(c, "host", host)
 	})
 
-	r.GET("/geo/:ip", func(c *gin.Context) {
-		if geo, err := getLocation(c.Param("ip")); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusOK, geo)
+	// Caller's own IP
+	r.GET("/myip", func(c *gin.Context) {
+		c.String(http.StatusOK, clientIP(c))
+	})
+
+	lookupRoute(r, "/whoami", func(c *gin.Context) {
+		lookup(c, "all", clientIP(c))
+	})
+
+	// All databases at once (admin only)
+	r.POST("/admin/reload", requireToken(true), reloadAllHandler)
+
+	// Dry run of a reload from a candidate file, looking up probe IPs (admin only)
+	r.POST("/admin/stage", requireToken(true), stageHandler)
+
+	// Every network of a database, as NDJSON (admin only)
+	r.GET("/admin/export", requireToken(true), limitResponse, exportHandler)
+
+	// Host requested through TLS server name indication (admin only)
+	r.GET("/sni", requireToken(true), func(c *gin.Context) {
+		switch {
+		case c.Request.TLS == nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "not a TLS request", "code": "no_tls"})
+		case c.Request.TLS.ServerName == "":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no server name in the TLS handshake", "code": "no_sni"})
+		default:
+			lookup(c, "host", c.Request.TLS.ServerName)
 		}
 	})
 
-	// IP Info (ASN + GeoIP combined)
-	r.GET("/ipinfo/:ip", func(c *gin.Context) {
-		if ipdata, err := getIPInfo(c.Param("ip")); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusOK, ipdata)
+	// Raw records of an IP in the databases (admin only)
+	r.GET("/admin/record/:ip", requireToken(true), recordHandler)
+
+	// Server's own egress IP (admin only)
+	r.GET("/server/ip", requireToken(true), func(c *gin.Context) {
+		ip, err := egressIP(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "code": "egress_lookup_failed"})
+			return
 		}
+		lookup(c, "all", ip)
 	})
 
-	r.Run(*addr)
-}
+	// Differences between primary and secondary databases (admin only)
+	r.GET("/compare", requireToken(true), func(c *gin.Context) {
+		if cmpAsnDB == nil && cmpGeoDB == nil {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": errNotConfigured.Error(), "code": "not_configured"})
+			return
+		}
+		ips := c.QueryArray("ip")
+		if len(ips) == 0 || len(ips) > *maxCompare {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("between 1 and %d ip addresses must be given", *maxCompare),
+				"code":  "bad_request",
+			})
+			return
+		}
+		o := requestOptions(c)
+		results := make([]comparison, 0, len(ips))
+		for _, ip := range ips {
+			results = append(results, compareIP(ip, o))
+		}
+		render(c, o, http.StatusOK, results)
+	})
 
-func getAS(ip string) (as, error) {
-	ipaddr := net.ParseIP(ip)
-	if ipaddr == nil {
-		return as{}, fmt.Errorf("invalid ip address %q", ip)
+	if *grpcAddr != "" {
+		l.grpc, l.grpcAddr = newGRPCServer(), *grpcAddr
 	}
-	data, err := asnReader.ASN(ipaddr)
-	if err != nil {
-		return as{}, err
+
+	if *checkEvery > 0 {
+		go selfCheck(*checkEvery, *checkFails)
 	}
-	return as{
-		Number: data.AutonomousSystemNumber,
-		Name:   data.AutonomousSystemOrganization,
-	}, nil
-}
 
-func getLocation(ip string) (location, error) {
-	ipaddr := net.ParseIP(ip)
-	if ipaddr == nil {
-		return location{}, fmt.Errorf("invalid ip address %q", ip)
-	}
-	geo, err := locReader.City(ipaddr)
-	if err != nil {
-		return location{}, err
-	}
-	return location{
-		Continent:     geo.Continent.Names[*lang],
-		ContinentCode: geo.Continent.Code,
-		Country:       geo.Country.Names[*lang],
-		CountryCode:   geo.Country.IsoCode,
-		City:          geo.City.Names[*lang],
-	}, nil
+	l.serve()
 }
 
-func getIPInfo(ip string) (ipinfo, error) {
-	ipaddr := net.ParseIP(ip)
-	if ipaddr == nil {
-		return ipinfo{}, fmt.Errorf("invalid ip address %q", ip)
-	}
-	ptrs, _ := net.LookupAddr(ip)
-	asData, _ := getAS(ip)
-	loData, err := getLocation(ip)
-	return ipinfo{
-		IP:        net.ParseIP(ip),
-		Hostnames: ptrs,
-		AS:        asData,
-		Location:  loData,
-	}, err
+// lookupRoute registers a lookup endpoint for both GET and HEAD requests, the
+// latter getting the same status and headers without a body. Oversized path
+// parameters are rejected beforehand.
+func lookupRoute(r *gin.Engine, path string, handler gin.HandlerFunc) {
+	r.GET(path, checkParams, handler)
+	r.HEAD(path, checkParams, handler)
 }
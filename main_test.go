@@ -0,0 +1,54 @@
+package main
+
+//go:generate sh -c "cd testdata/gen && go run ."
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain configures the service with the fixture databases of testdata,
+// generated with go generate.
+func TestMain(m *testing.M) {
+	os.Setenv("IPINFO_DB_ASN", "testdata/asn.mmdb")
+	os.Setenv("IPINFO_DB_GEOIP", "testdata/city.mmdb")
+	os.Setenv("IPINFO_MODE", "test")
+	setup()
+	os.Exit(m.Run())
+}
+
+// testOptions are the default options, without reverse DNS lookups.
+func testOptions() options {
+	o := defaultOptions()
+	o.hostnames = false
+	return o
+}
+
+// closedReader opens a reader of the fixture file and closes it, as reloads do
+// with the readers they replace.
+func closedReader(t testing.TB, file string) *dbReader {
+	t.Helper()
+	r, err := openReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.retire()
+	return r
+}
+
+// withDatabase loads the fixture file as *global for the duration of the test,
+// e.g. the Enterprise database, restoring the configured one afterwards.
+func withDatabase(t testing.TB, global **database, name, file string) *database {
+	t.Helper()
+	db := &database{name: name, file: &file}
+	if err := db.load(); err != nil {
+		t.Fatal(err)
+	}
+	previous := *global
+	*global = db
+	t.Cleanup(func() {
+		*global = previous
+		db.reader().Close()
+	})
+	return db
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipinfo_http_requests_total",
+		Help: "Number of HTTP requests by route and status code.",
+	}, []string{"method", "route", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ipinfo_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	languageRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipinfo_language_requests_total",
+		Help: "Number of requests by resolved language, each of them counted for requests in several.",
+	}, []string{"lang"})
+
+	languageFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipinfo_language_fallbacks_total",
+		Help: "Number of names read in another language than requested, by requested language and the one used instead (unknown for the placeholder).",
+	}, []string{"lang", "fallback"})
+)
+
+// databaseAge is the age of the build of each loaded database, computed when
+// scraped so that it keeps growing between loads.
+var databaseAge = prometheus.NewDesc(
+	"ipinfo_database_age_seconds",
+	"Time since the build of each loaded database.",
+	[]string{"database"}, nil,
+)
+
+// databaseCollector exports metrics about the databases currently loaded.
+type databaseCollector struct{}
+
+func (databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- databaseAge
+}
+
+func (databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, db := range databases {
+		if r := db.reader(); r != nil {
+			built := time.Unix(int64(r.Metadata().BuildEpoch), 0)
+			ch <- prometheus.MustNewConstMetric(databaseAge, prometheus.GaugeValue, time.Since(built).Seconds(), db.name)
+		}
+	}
+}
+
+func init() {
+	prometheus.MustRegister(databaseCollector{})
+}
+
+// instrument records request count and latency for every matched route.
+func instrument(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	requestsTotal.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	requestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
+
+// registerObservability adds the /metrics and health endpoints to r. The
+// /livez liveness endpoint always succeeds while the process is up, whereas
+// the /readyz readiness one fails with a 503 until every database is loaded
+// and usable: a database failing to load should get the instance out of load
+// balancing, not restarted. /healthz reports the state of the databases.
+func registerObservability(r *gin.Engine) {
+	r.GET("/livez", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	r.GET("/readyz", func(c *gin.Context) {
+		errs := make(map[string]string)
+		for _, db := range databases {
+			if err := db.ready(); err != nil {
+				errs[db.name] = err.Error()
+			}
+		}
+		if len(errs) > 0 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not_ready", "databases": errs})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/healthz", func(c *gin.Context) {
+		status, infos := "ok", databasesInfo()
+		for _, info := range infos {
+			if info.Degraded {
+				status = "degraded"
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status, "databases": infos})
+	})
+}
+
+// registerProfiler adds the admin-only /debug/pprof endpoints to r.
+func registerProfiler(r *gin.Engine) {
+	r.Any("/debug/pprof/*name", requireToken(true), func(c *gin.Context) {
+		switch c.Param("name") {
+		case "/cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "/profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "/symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "/trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			// Index also serves the named profiles (heap, goroutine...)
+			pprof.Index(c.Writer, c.Request)
+		}
+	})
+}
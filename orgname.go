@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// orgRules are the -org_normalization rules AS organization names are
+// normalized with, into as.Normalized; none when nil.
+var orgRules map[string]bool
+
+// orgNormalizations are the available rules, applied in this order whichever
+// order they are configured in.
+var orgNormalizations = []string{"space", "country", "suffix", "case"}
+
+var (
+	spaces = regexp.MustCompile(`\s+`)
+	// e.g. "CLOUDFLARENET - Cloudflare, Inc., US" or "GOOGLE - Google LLC"
+	countrySuffix = regexp.MustCompile(`,\s*([A-Z]{2})$`)
+	legalSuffix   = regexp.MustCompile(`(?i)[,\s]+(llc|l\.l\.c\.|inc\.?|incorporated|ltd\.?|limited|corp\.?|corporation|co\.?|gmbh|ag|s\.?a\.?|sas|b\.?v\.?|plc|pty|llp|srl|oy|ab)$`)
+)
+
+// parseOrgRules parses comma-separated rules, ignoring unknown ones.
+func parseOrgRules(s string) map[string]bool {
+	var rules map[string]bool
+	for _, rule := range splitList(s) {
+		for _, known := range orgNormalizations {
+			if rule == known {
+				if rules == nil {
+					rules = make(map[string]bool)
+				}
+				rules[rule] = true
+			}
+		}
+	}
+	return rules
+}
+
+// normalizeOrg normalizes name with the configured rules: space collapses and
+// trims whitespace, country removes trailing country codes, suffix removes
+// trailing legal forms (LLC, Inc., GmbH...) and case upper-cases the name, so
+// that e.g. "Google LLC" and "GOOGLE" both become "GOOGLE".
+func normalizeOrg(name string) string {
+	if orgRules["space"] {
+		name = strings.TrimSpace(spaces.ReplaceAllString(name, " "))
+	}
+	if orgRules["country"] {
+		if m := countrySuffix.FindStringSubmatch(name); m != nil && alpha3[m[1]] != "" {
+			name = strings.TrimSpace(name[:len(name)-len(m[0])])
+		}
+	}
+	if orgRules["suffix"] {
+		for {
+			trimmed := legalSuffix.ReplaceAllString(name, "")
+			if trimmed == name || trimmed == "" {
+				break
+			}
+			name = strings.TrimRight(trimmed, ", ")
+		}
+	}
+	if orgRules["case"] {
+		name = strings.ToUpper(name)
+	}
+	return name
+}
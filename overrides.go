@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+)
+
+// override patches the AS and location of the IPs of a network, for operators
+// to correct the database where it is known to be wrong. Fields are given as
+// they are rendered, names as plain strings (used for every language) or by
+// language code, e.g.:
+//
+//	[{"Network": "203.0.113.0/24", "Location": {"CountryCode": "FR", "City": "Paris"}}]
+type override struct {
+	Network  string
+	AS       map[string]interface{}
+	Location map[string]interface{}
+
+	ipNet *net.IPNet
+}
+
+// overrides are sorted from the most specific network to the least one.
+var overrides []override
+
+// loadOverrides reads the overrides of file.
+func loadOverrides(file string) ([]override, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var list []override
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("invalid overrides file %s: %v", file, err)
+	}
+	for i := range list {
+		if _, list[i].ipNet, err = net.ParseCIDR(list[i].Network); err != nil {
+			return nil, fmt.Errorf("invalid override network %q: %v", list[i].Network, err)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, _ := list[i].ipNet.Mask.Size()
+		b, _ := list[j].ipNet.Mask.Size()
+		return a > b
+	})
+	return list, nil
+}
+
+// override merges the fields of the most specific override of ip with fields
+// for result (an *as or *location), if any, into it and flags it as Overridden. IPs the database
+// has no data for get the override alone; err is returned as is otherwise, or
+// when merging fails.
+func (o options) override(ip string, result interface{}, err error) error {
+	if len(overrides) == 0 || (err != nil && !errors.Is(err, errNotFound)) {
+		return err
+	}
+	ipaddr := net.ParseIP(ip)
+	for _, ov := range overrides {
+		if ipaddr == nil || !ov.ipNet.Contains(ipaddr) {
+			continue
+		}
+		switch r := result.(type) {
+		case *as:
+			if ov.AS == nil {
+				continue
+			}
+			var patched as
+			if mergeErr := mergeFields(*r, ov.AS, &patched, o.lang); mergeErr != nil {
+				return mergeErr
+			}
+			patched.Overridden = true
+			*r = patched
+		case *location:
+			if ov.Location == nil {
+				continue
+			}
+			if err != nil {
+				*r = location{Continent: o.localize(nil), Country: o.localize(nil), City: o.localize(nil), Subdivisions: []subdivision{}}
+			}
+			var patched location
+			if mergeErr := mergeFields(*r, ov.Location, &patched, o.lang); mergeErr != nil {
+				return mergeErr
+			}
+			if _, ok := ov.Location["CountryCode3"]; !ok && patched.CountryCode != r.CountryCode {
+				patched.CountryCode3 = alpha3[patched.CountryCode]
+			}
+			patched.Overridden, patched.registeredCountry = true, r.registeredCountry
+			o.redactLocation(&patched)
+			*r = patched
+		}
+		return nil
+	}
+	return err
+}
+
+// mergeFields renders from, merges patch into it and parses the result into to.
+func mergeFields(from interface{}, patch map[string]interface{}, to interface{}, lang string) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	merge(fields, patch, lang)
+	if b, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+// merge sets the fields of patch in dst, recursively. Plain string names
+// replace every language of names rendered by language, and names given by
+// language replace that of lang when a single one is rendered.
+func merge(dst, patch map[string]interface{}, lang string) {
+	for k, v := range patch {
+		switch d := dst[k].(type) {
+		case map[string]interface{}:
+			switch p := v.(type) {
+			case map[string]interface{}:
+				merge(d, p, lang)
+				continue
+			case string:
+				for l := range d {
+					d[l] = p
+				}
+				continue
+			}
+		case string:
+			if p, ok := v.(map[string]interface{}); ok {
+				if name, ok := p[lang]; ok {
+					dst[k] = name
+				}
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// IPInfoServiceClient is the client API for IPInfoService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type IPInfoServiceClient interface {
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*IPInfo, error)
+	LookupASN(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*AS, error)
+	LookupGeo(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*Location, error)
+	LookupBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+}
+
+type iPInfoServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIPInfoServiceClient(cc grpc.ClientConnInterface) IPInfoServiceClient {
+	return &iPInfoServiceClient{cc}
+}
+
+func (c *iPInfoServiceClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*IPInfo, error) {
+	out := new(IPInfo)
+	err := c.cc.Invoke(ctx, "/ipinfo.IPInfoService/Lookup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iPInfoServiceClient) LookupASN(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*AS, error) {
+	out := new(AS)
+	err := c.cc.Invoke(ctx, "/ipinfo.IPInfoService/LookupASN", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iPInfoServiceClient) LookupGeo(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*Location, error) {
+	out := new(Location)
+	err := c.cc.Invoke(ctx, "/ipinfo.IPInfoService/LookupGeo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iPInfoServiceClient) LookupBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/ipinfo.IPInfoService/LookupBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IPInfoServiceServer is the server API for IPInfoService service.
+// All implementations must embed UnimplementedIPInfoServiceServer
+// for forward compatibility
+type IPInfoServiceServer interface {
+	Lookup(context.Context, *LookupRequest) (*IPInfo, error)
+	LookupASN(context.Context, *LookupRequest) (*AS, error)
+	LookupGeo(context.Context, *LookupRequest) (*Location, error)
+	LookupBatch(context.Context, *BatchRequest) (*BatchResponse, error)
+	mustEmbedUnimplementedIPInfoServiceServer()
+}
+
+// UnimplementedIPInfoServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedIPInfoServiceServer struct {
+}
+
+func (UnimplementedIPInfoServiceServer) Lookup(context.Context, *LookupRequest) (*IPInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedIPInfoServiceServer) LookupASN(context.Context, *LookupRequest) (*AS, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupASN not implemented")
+}
+func (UnimplementedIPInfoServiceServer) LookupGeo(context.Context, *LookupRequest) (*Location, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupGeo not implemented")
+}
+func (UnimplementedIPInfoServiceServer) LookupBatch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupBatch not implemented")
+}
+func (UnimplementedIPInfoServiceServer) mustEmbedUnimplementedIPInfoServiceServer() {}
+
+// UnsafeIPInfoServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IPInfoServiceServer will
+// result in compilation errors.
+type UnsafeIPInfoServiceServer interface {
+	mustEmbedUnimplementedIPInfoServiceServer()
+}
+
+func RegisterIPInfoServiceServer(s grpc.ServiceRegistrar, srv IPInfoServiceServer) {
+	s.RegisterService(&IPInfoService_ServiceDesc, srv)
+}
+
+func _IPInfoService_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IPInfoServiceServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ipinfo.IPInfoService/Lookup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IPInfoServiceServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IPInfoService_LookupASN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IPInfoServiceServer).LookupASN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ipinfo.IPInfoService/LookupASN",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IPInfoServiceServer).LookupASN(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IPInfoService_LookupGeo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IPInfoServiceServer).LookupGeo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ipinfo.IPInfoService/LookupGeo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IPInfoServiceServer).LookupGeo(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IPInfoService_LookupBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IPInfoServiceServer).LookupBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ipinfo.IPInfoService/LookupBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IPInfoServiceServer).LookupBatch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// IPInfoService_ServiceDesc is the grpc.ServiceDesc for IPInfoService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IPInfoService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ipinfo.IPInfoService",
+	HandlerType: (*IPInfoServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _IPInfoService_Lookup_Handler,
+		},
+		{
+			MethodName: "LookupASN",
+			Handler:    _IPInfoService_LookupASN_Handler,
+		},
+		{
+			MethodName: "LookupGeo",
+			Handler:    _IPInfoService_LookupGeo_Handler,
+		},
+		{
+			MethodName: "LookupBatch",
+			Handler:    _IPInfoService_LookupBatch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ipinfo.proto",
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// conflict is reported in debug info when the specific and the Enterprise
+// databases both have data for an IP, but disagree on some of its fields.
+type conflict struct {
+	Winner     string   // database whose data was returned
+	Overridden string   // database whose data was discarded
+	Fields     []string // which differ, as "Key.SubKey" paths
+}
+
+// resolve runs lookup against the specific database and, when configured, the
+// combined Enterprise one. By order of precedence (-db_precedence), the first
+// database with data for the IP wins, the specific one by default; the other
+// one is only used when the first has no data or isn't loaded yet. When
+// debugging, both are looked up and their differences recorded as a conflict
+// on field, and the database the result was read from as its provider.
+func resolve(o options, field string, specific *database, lookup func(*database) (interface{}, error)) (interface{}, error) {
+	if enterpriseDB == nil {
+		data, err := lookup(specific)
+		if err == nil {
+			o.provide(field, specific)
+		}
+		return data, err
+	}
+	first, second := specific, enterpriseDB
+	if *precedence == "enterprise" {
+		first, second = second, first
+	}
+	data, err := lookup(first)
+	if errors.Is(err, errNotFound) || errors.Is(err, errNotLoaded) {
+		if other, otherErr := lookup(second); otherErr == nil {
+			o.provide(field, second)
+			return other, nil
+		}
+		return data, err
+	}
+	if err != nil {
+		return data, err
+	}
+	o.provide(field, first)
+	if o.conflicts == nil {
+		return data, nil
+	}
+	if other, err := lookup(second); err == nil {
+		if fields := differences(data, other); len(fields) > 0 {
+			o.debugMu.Lock()
+			o.conflicts[field] = conflict{Winner: first.name, Overridden: second.name, Fields: fields}
+			o.debugMu.Unlock()
+		}
+	}
+	return data, nil
+}
+
+// provide records db as the provider of field, when debugging.
+func (o options) provide(field string, db *database) {
+	if o.providers != nil {
+		o.debugMu.Lock()
+		o.providers[field] = db.name
+		o.debugMu.Unlock()
+	}
+}
+
+// differences lists the sorted paths of the fields differing between a and b.
+func differences(a, b interface{}) []string {
+	fa, _ := flatten(a)
+	fb, _ := flatten(b)
+	var fields []string
+	for k, v := range fa {
+		if w, ok := fb[k]; !ok || w != v {
+			fields = append(fields, k)
+		}
+	}
+	for k := range fb {
+		if _, ok := fa[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrecedence(t *testing.T) {
+	withDatabase(t, &enterpriseDB, "enterprise", "testdata/enterprise.mmdb")
+	defer func(p string) { *precedence = p }(*precedence)
+	tests := []struct {
+		precedence, ip string
+		name, provider string
+		conflict       *conflict
+	}{
+		{"specific", "8.8.8.8", "Google LLC", "asn", &conflict{Winner: "asn", Overridden: "enterprise", Fields: []string{"CountryCode", "Name"}}},
+		{"enterprise", "8.8.8.8", "GOOGLE LLC", "enterprise", &conflict{Winner: "enterprise", Overridden: "asn", Fields: []string{"CountryCode", "Name"}}},
+		{"specific", "7.7.7.7", "LEVEL3", "enterprise", nil}, // no data in the ASN database
+		{"enterprise", "1.1.1.1", "CLOUDFLARENET", "asn", nil},
+	}
+	for _, tt := range tests {
+		*precedence = tt.precedence
+		o := testOptions()
+		o.debug, o.enrich = true, map[string]bool{"asn": true}
+		info, err := getIPInfo(tt.ip, o)
+		if err != nil {
+			t.Fatalf("getIPInfo(%s) with %s precedence: %v", tt.ip, tt.precedence, err)
+		}
+		if info.AS.Name != tt.name || info.Debug.Providers["AS"] != tt.provider {
+			t.Errorf("%s with %s precedence: AS %q from %s, want %q from %s", tt.ip, tt.precedence, info.AS.Name, info.Debug.Providers["AS"], tt.name, tt.provider)
+		}
+		if c, ok := info.Debug.Conflicts["AS"]; ok != (tt.conflict != nil) || (ok && !reflect.DeepEqual(c, *tt.conflict)) {
+			t.Errorf("%s with %s precedence: conflict %+v, want %+v", tt.ip, tt.precedence, c, tt.conflict)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseRange parses either a CIDR block or a start-end pair of IPs of the
+// same family (e.g. 1.2.3.0-1.2.3.255) into the prefixes covering it.
+func parseRange(s string) ([]*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.IPNet{n}, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid range %q, expected a CIDR block or start-end ips", s)
+	}
+	start, end := net.ParseIP(strings.TrimSpace(parts[0])), net.ParseIP(strings.TrimSpace(parts[1]))
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("invalid range %q, expected a CIDR block or start-end ips", s)
+	}
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return nil, errors.New("range start and end must be of the same family")
+	}
+	bits := 128
+	if start.To4() != nil {
+		start, end, bits = start.To4(), end.To4(), 32
+	}
+	lo, hi := new(big.Int).SetBytes(start), new(big.Int).SetBytes(end)
+	if lo.Cmp(hi) > 0 {
+		return nil, errors.New("range start must not be after its end")
+	}
+	return rangePrefixes(lo, hi, bits), nil
+}
+
+// rangePrefixes returns the smallest list of prefixes covering lo to hi:
+// from lo, each prefix is the largest one aligned on it and not past hi.
+func rangePrefixes(lo, hi *big.Int, bits int) []*net.IPNet {
+	var nets []*net.IPNet
+	one := big.NewInt(1)
+	for lo.Cmp(hi) <= 0 {
+		size := 0
+		for size < bits && lo.Bit(size) == 0 {
+			last := new(big.Int).Add(lo, new(big.Int).Lsh(one, uint(size+1)))
+			if last.Sub(last, one).Cmp(hi) > 0 {
+				break
+			}
+			size++
+		}
+		nets = append(nets, &net.IPNet{IP: toIP(lo, bits), Mask: net.CIDRMask(bits-size, bits)})
+		lo = new(big.Int).Add(lo, new(big.Int).Lsh(one, uint(size)))
+	}
+	return nets
+}
+
+func toIP(n *big.Int, bits int) net.IP {
+	ip := make(net.IP, bits/8)
+	return n.FillBytes(ip)
+}
+
+func prefixSize(n *net.IPNet) *big.Int {
+	ones, bits := n.Mask.Size()
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
+
+// rangeHosts returns every address of the prefixes.
+func rangeHosts(nets []*net.IPNet) []string {
+	var hosts []string
+	for _, n := range nets {
+		_, bits := n.Mask.Size()
+		ip, last := new(big.Int).SetBytes(n.IP), new(big.Int).SetBytes(n.IP)
+		last.Add(last, prefixSize(n))
+		for ; ip.Cmp(last) < 0; ip.Add(ip, big.NewInt(1)) {
+			hosts = append(hosts, toIP(ip, bits).String())
+		}
+	}
+	return hosts
+}
+
+// rangeEndpoints returns the first and last addresses of the prefixes.
+func rangeEndpoints(nets []*net.IPNet) []string {
+	var hosts []string
+	for _, n := range nets {
+		_, bits := n.Mask.Size()
+		first := new(big.Int).SetBytes(n.IP)
+		last := new(big.Int).Add(first, prefixSize(n))
+		hosts = append(hosts, toIP(first, bits).String())
+		if last.Sub(last, big.NewInt(1)).Cmp(first) > 0 {
+			hosts = append(hosts, toIP(last, bits).String())
+		}
+	}
+	return hosts
+}
+
+// rangeSample returns n distinct addresses of the prefixes, picked at random
+// and sorted; the prefixes must hold more than n addresses.
+func rangeSample(nets []*net.IPNet, n int) ([]string, error) {
+	total := new(big.Int)
+	for _, p := range nets {
+		total.Add(total, prefixSize(p))
+	}
+	picked := make(map[string]bool, n)
+	var offsets []*big.Int
+	for len(offsets) < n {
+		offset, err := rand.Int(rand.Reader, total)
+		if err != nil {
+			return nil, err
+		}
+		if !picked[offset.String()] {
+			picked[offset.String()] = true
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Cmp(offsets[j]) < 0 })
+
+	hosts := make([]string, 0, n)
+	base := new(big.Int) // offset of the current prefix
+	p := 0
+	for _, offset := range offsets {
+		for new(big.Int).Sub(offset, base).Cmp(prefixSize(nets[p])) >= 0 {
+			base.Add(base, prefixSize(nets[p]))
+			p++
+		}
+		_, bits := nets[p].Mask.Size()
+		ip := new(big.Int).SetBytes(nets[p].IP)
+		hosts = append(hosts, toIP(ip.Add(ip, offset.Sub(offset, base)), bits).String())
+	}
+	return hosts, nil
+}
+
+// lookupRange looks up every IP of the ?range= CIDR block or start-end pair,
+// like a bulk request. Ranges of more than the configured maximum number of
+// hosts are refused, unless sampling is enabled: then, only the endpoints of
+// the covering prefixes, or a random sample of the range, are looked up. The
+// X-Range-Sampled header tells which strategy was used, if any.
+func lookupRange(c *gin.Context) {
+	o := requestOptions(c)
+	nets, err := parseRange(c.Query("range"))
+	if err != nil {
+		render(c, o, http.StatusBadRequest, gin.H{"error": err.Error(), "code": "bad_request"})
+		return
+	}
+	total := new(big.Int)
+	for _, n := range nets {
+		total.Add(total, prefixSize(n))
+	}
+
+	var ips []string
+	switch {
+	case total.Cmp(big.NewInt(int64(*maxRangeHosts))) <= 0:
+		ips = rangeHosts(nets)
+	case *rangeSampling == "endpoints":
+		c.Header("X-Range-Sampled", "endpoints")
+		ips = rangeEndpoints(nets)
+	case *rangeSampling == "random":
+		c.Header("X-Range-Sampled", "random")
+		if ips, err = rangeSample(nets, *maxRangeHosts); err != nil {
+			render(c, o, http.StatusInternalServerError, gin.H{"error": err.Error(), "code": "internal"})
+			return
+		}
+	default:
+		render(c, o, http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("range of %s ip addresses, at most %d can be looked up at once", total, *maxRangeHosts),
+			"code":  "range_too_large",
+		})
+		return
+	}
+	lookupAll(c, o, ips)
+}
@@ -0,0 +1,98 @@
+package main
+
+import "strings"
+
+// redactable are the fields which can be redacted from responses.
+var redactable = []string{"city", "subdivisions", "latitude", "longitude", "accuracy_radius", "network", "hostnames"}
+
+var (
+	// redactFields are redacted from every response.
+	redactFields map[string]bool
+	// redactByToken are redacted, in addition, from the responses to requests
+	// authenticated with the given bearer token.
+	redactByToken map[string]map[string]bool
+)
+
+// parseRedactFields parses a list of fields separated by sep, skipping the
+// ones which can't be redacted.
+func parseRedactFields(s, sep string) map[string]bool {
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(s, sep) {
+		f = strings.ToLower(strings.TrimSpace(f))
+		for _, r := range redactable {
+			if f == r {
+				fields[f] = true
+			}
+		}
+	}
+	return fields
+}
+
+// parseRedactTokens parses "token:field|field" pairs.
+func parseRedactTokens(s string) map[string]map[string]bool {
+	tokens := make(map[string]map[string]bool)
+	for _, pair := range splitList(s) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			tokens[parts[0]] = parseRedactFields(parts[1], "|")
+		}
+	}
+	return tokens
+}
+
+// redactionsFor returns the fields redacted for the given bearer token.
+func redactionsFor(token string) map[string]bool {
+	extra := redactByToken[token]
+	if token == "" || len(extra) == 0 {
+		return redactFields
+	}
+	fields := make(map[string]bool, len(redactFields)+len(extra))
+	for f := range redactFields {
+		fields[f] = true
+	}
+	for f := range extra {
+		fields[f] = true
+	}
+	return fields
+}
+
+// redactLocation empties the redacted fields of loc.
+func (o options) redactLocation(loc *location) {
+	if o.redacted["city"] {
+		loc.City = o.localize(nil)
+	}
+	if o.redacted["subdivisions"] {
+		loc.Subdivisions = loc.Subdivisions[:0:0] // not to append to a shared array
+	}
+	if o.redacted["latitude"] {
+		loc.Latitude = nil
+	}
+	if o.redacted["longitude"] {
+		loc.Longitude = nil
+	}
+	if o.redacted["accuracy_radius"] {
+		loc.AccuracyRadius = 0
+	}
+	if o.redacted["network"] {
+		loc.Network = ""
+	}
+}
+
+// redactInfo empties the redacted fields of info. Its AS and location are
+// copied first, as they may be shared with other results (e.g. those of the
+// snapshot).
+func (o options) redactInfo(info *ipinfo) {
+	if o.redacted["hostnames"] {
+		info.Hostnames = nil
+	}
+	if o.redacted["network"] && info.AS != nil {
+		asn := *info.AS
+		asn.Network = ""
+		info.AS = &asn
+	}
+	if info.Location != nil {
+		loc := *info.Location
+		o.redactLocation(&loc)
+		info.Location = &loc
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errResponseTooLarge is returned by the writes of responses past
+// -max_response_size, for streaming handlers to stop there.
+var errResponseTooLarge = errors.New("response too large")
+
+// limitedWriter caps the size of a response at limit bytes. A response
+// written at once past it is replaced by a 413 error; a streamed one is
+// truncated instead, the write crossing the limit being replaced by an error
+// line, as its status and first lines are already sent.
+type limitedWriter struct {
+	gin.ResponseWriter
+	limit, written int
+	exceeded       bool
+}
+
+// limitResponse caps the size of the responses of the route, when configured
+// with -max_response_size.
+func limitResponse(c *gin.Context) {
+	if *maxResponseSize > 0 {
+		c.Writer = &limitedWriter{ResponseWriter: c.Writer, limit: *maxResponseSize}
+	}
+	c.Next()
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if w.exceeded {
+		return 0, errResponseTooLarge
+	}
+	if w.written+len(b) <= w.limit {
+		n, err := w.ResponseWriter.Write(b)
+		w.written += n
+		return n, err
+	}
+	w.exceeded = true
+	marker, _ := json.Marshal(gin.H{
+		"error": fmt.Sprintf("response larger than %d bytes", w.limit),
+		"code":  "response_too_large",
+	})
+	if !w.ResponseWriter.Written() {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+	w.ResponseWriter.Write(append(marker, '\n'))
+	return 0, errResponseTooLarge
+}
+
+func (w *limitedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Response schema versions, selected with ?v= or an Accept header such as
+// application/vnd.ipinfo.v1+json. Version 1 is frozen: fields added since then
+// are only part of later versions, so the v1 types below must not change.
+const (
+	schemaV1     = 1
+	schemaLatest = 2
+)
+
+var vendorAccept = regexp.MustCompile(`application/vnd\.ipinfo\.v(\d+)\+json`)
+
+// requestVersion returns the requested schema version, defaulting to the
+// latest one; unknown versions are ignored.
+func requestVersion(query, accept string) int {
+	v := query
+	if v == "" {
+		if m := vendorAccept.FindStringSubmatch(accept); m != nil {
+			v = m[1]
+		}
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= schemaV1 && n <= schemaLatest {
+		return n
+	}
+	return schemaLatest
+}
+
+type asV1 struct {
+	Number  uint
+	Name    string
+	Network string `json:",omitempty"`
+}
+
+type locationV1 struct {
+	Continent          localized
+	ContinentCode      string
+	Country            localized
+	CountryCode        string
+	City               localized
+	Subdivisions       []subdivision
+	RepresentedCountry *representedCountry `json:",omitempty"`
+	Latitude           *float64            `json:",omitempty"`
+	Longitude          *float64            `json:",omitempty"`
+	AccuracyRadius     uint16              `json:",omitempty"`
+	Downgraded         bool                `json:",omitempty"`
+	Network            string              `json:",omitempty"`
+}
+
+// MarshalJSON renders missing coordinates like location does.
+func (l locationV1) MarshalJSON() ([]byte, error) {
+	type plain locationV1
+	if *missingCoords != "null" || l.Latitude != nil {
+		return marshalJSON(plain(l))
+	}
+	return marshalJSON(struct {
+		plain
+		Latitude, Longitude *float64
+	}{plain: plain(l)})
+}
+
+type ipinfoV1 struct {
+	IP        net.IP
+	Hostnames []string
+	AS        *asV1        `json:",omitempty"`
+	Location  *locationV1  `json:",omitempty"`
+	ISP       *isp         `json:",omitempty"`
+	Anonymity *anonymity   `json:",omitempty"`
+	Domain    string       `json:",omitempty"`
+	Debug     *debugInfoV1 `json:",omitempty"`
+}
+
+type debugInfoV1 struct {
+	Sources       map[string]sourceV1
+	LookupSeconds map[string]float64
+}
+
+type sourceV1 struct {
+	File      string
+	Type      string
+	BuildDate time.Time
+}
+
+type bulkEntryV1 struct {
+	IP     string
+	Result *ipinfoV1 `json:",omitempty"`
+	Error  string    `json:",omitempty"`
+}
+
+// toVersion maps lookup results to the given schema version; other objects
+// are left as is.
+func toVersion(obj interface{}, version int) interface{} {
+	if version != schemaV1 {
+		return obj
+	}
+	switch v := obj.(type) {
+	case as:
+		return asToV1(v)
+	case location:
+		return locationToV1(v)
+	case ipinfo:
+		return ipinfoToV1(v)
+	case bulkEntry:
+		return bulkEntryToV1(v)
+	case []bulkEntry:
+		entries := make([]bulkEntryV1, len(v))
+		for i, e := range v {
+			entries[i] = bulkEntryToV1(e)
+		}
+		return entries
+	}
+	return obj
+}
+
+func bulkEntryToV1(e bulkEntry) bulkEntryV1 {
+	v1 := bulkEntryV1{IP: e.IP, Error: e.Error}
+	if e.Result != nil {
+		info := ipinfoToV1(*e.Result)
+		v1.Result = &info
+	}
+	return v1
+}
+
+func ipinfoToV1(info ipinfo) ipinfoV1 {
+	v1 := ipinfoV1{
+		IP:        info.IP,
+		Hostnames: info.Hostnames,
+		ISP:       info.ISP,
+		Anonymity: info.Anonymity,
+		Domain:    info.Domain,
+	}
+	if info.Debug != nil {
+		v1.Debug = debugToV1(*info.Debug)
+	}
+	if info.AS != nil {
+		asn := asToV1(*info.AS)
+		v1.AS = &asn
+	}
+	if info.Location != nil {
+		loc := locationToV1(*info.Location)
+		v1.Location = &loc
+	}
+	return v1
+}
+
+func debugToV1(debug debugInfo) *debugInfoV1 {
+	v1 := &debugInfoV1{Sources: make(map[string]sourceV1, len(debug.Sources)), LookupSeconds: debug.LookupSeconds}
+	for field, src := range debug.Sources {
+		v1.Sources[field] = sourceV1{File: src.File, Type: src.Type, BuildDate: src.BuildDate}
+	}
+	return v1
+}
+
+func asToV1(asn as) asV1 {
+	return asV1{Number: asn.Number, Name: asn.Name, Network: asn.Network}
+}
+
+// locationToV1 leaves approximated coordinates out, v1 having no way to flag
+// them.
+func locationToV1(loc location) locationV1 {
+	if loc.Approximated != "" {
+		loc.Latitude, loc.Longitude = nil, nil
+	}
+	return locationV1{
+		Continent:          loc.Continent,
+		ContinentCode:      loc.ContinentCode,
+		Country:            loc.Country,
+		CountryCode:        loc.CountryCode,
+		City:               loc.City,
+		Subdivisions:       loc.Subdivisions,
+		RepresentedCountry: loc.RepresentedCountry,
+		Latitude:           loc.Latitude,
+		Longitude:          loc.Longitude,
+		AccuracyRadius:     loc.AccuracyRadius,
+		Downgraded:         loc.Downgraded,
+		Network:            loc.Network,
+	}
+}
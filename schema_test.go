@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// keys returns the sorted keys of a JSON object.
+func keys(t *testing.T, b []byte) []string {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func TestV1DebugFrozen(t *testing.T) {
+	o := testOptions()
+	o.debug = true
+	info, err := getIPInfo("8.8.8.8", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(toVersion(info, schemaV1).(ipinfoV1).Debug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keys(t, b), []string{"LookupSeconds", "Sources"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("v1 debug fields = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"google.golang.org/grpc"
+)
+
+// listeners are the servers run until shutdown.
+type listeners struct {
+	http []*http.Server
+	tls  map[*http.Server]bool // served with -tls_cert and -tls_key
+
+	grpc     *grpc.Server
+	grpcAddr string
+}
+
+// add registers the HTTP server of handler on addr.
+func (l *listeners) add(addr string, handler http.Handler, tls bool) {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	l.http = append(l.http, srv)
+	if tls {
+		if l.tls == nil {
+			l.tls = make(map[*http.Server]bool)
+		}
+		l.tls[srv] = true
+	}
+}
+
+// serve runs every listener until SIGINT or SIGTERM, then shuts them all down,
+// see shutdown.
+func (l *listeners) serve() {
+	l.start()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("shutting down on %v", <-stop)
+	l.shutdown()
+}
+
+// start runs every listener in the background.
+func (l *listeners) start() {
+	for _, srv := range l.http {
+		srv := srv
+		go func() {
+			var err error
+			if l.tls[srv] {
+				err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				panic(err)
+			}
+		}()
+	}
+
+	if l.grpc != nil {
+		lis, err := net.Listen("tcp", l.grpcAddr)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			if err := l.grpc.Serve(lis); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+}
+
+// shutdown stops every listener gracefully: in-flight requests are given up to
+// -shutdown_timeout to complete, after which connections are closed and the
+// databases with them.
+func (l *listeners) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range l.http {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("%s listener: %v", srv.Addr, err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	if l.grpc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				l.grpc.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				l.grpc.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	closeDatabases()
+	audit.close()
+}
+
+// closeDatabases closes the reader of every database (once the requests which
+// pinned it complete), which can't be loaded anymore afterwards.
+func closeDatabases() {
+	for _, db := range databases {
+		db.loading.Lock()
+		if r := db.reader(); r != nil {
+			r.retire()
+		}
+		db.closed = true
+		db.loading.Unlock()
+	}
+}
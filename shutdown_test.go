@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// get reports whether a GET of url succeeded, retrying while the listener
+// starts up.
+func get(url string, retries int) bool {
+	for i := 0; ; i++ {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}
+		if i >= retries {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// Databases of its own, the shutdown closing them
+	defer func(dbs []*database) { databases = dbs }(databases)
+	databases = nil
+	for _, file := range []string{"testdata/asn.mmdb", "testdata/city.mmdb"} {
+		file := file
+		if err := newDatabase(file, &file, "", nil).load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readers := make([]*dbReader, len(databases))
+	for i, db := range databases {
+		readers[i] = db.reader()
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var l listeners
+	addrs := []string{freeAddr(t), freeAddr(t)} // main and metrics listeners
+	for _, addr := range addrs {
+		l.add(addr, ok, false)
+	}
+	l.start()
+	for _, addr := range addrs {
+		if !get("http://"+addr, 50) {
+			t.Fatalf("%s listener not serving", addr)
+		}
+	}
+
+	l.shutdown()
+	for _, addr := range addrs {
+		if get("http://"+addr, 0) {
+			t.Errorf("%s listener still serving after shutdown", addr)
+		}
+	}
+	for i, db := range databases {
+		if !readers[i].closed || !db.closed {
+			t.Errorf("%s database not closed on shutdown", db.name)
+		}
+		if err := db.load(); err != ErrDatabaseClosed {
+			t.Errorf("%s database loaded after shutdown: %v", db.name, err)
+		}
+	}
+	closeDatabases() // closing again is harmless
+}
@@ -0,0 +1,56 @@
+package main
+
+// cityDBs returns the databases providing coordinates, in lookup order.
+func cityDBs() []*database {
+	dbs := []*database{geoDB}
+	if enterpriseDB != nil {
+		dbs = append(dbs, enterpriseDB)
+	}
+	return append(dbs, geoFallbacks...)
+}
+
+// smooth replaces the coordinates of loc by those of every city database with
+// some for ip in the same country, when there are several: their centroid, or
+// the most accurate of them (the one with the smallest accuracy radius), as
+// configured with -geo_smoothing. Complementary databases then agree on a
+// stable coordinate, rather than the first one with data winning. Locations
+// without coordinates of their own (redacted, downgraded, approximated or the
+// -missing_coords=zero placeholder) are left as is, and databases without any
+// don't count.
+func (o options) smooth(ip string, loc *location) {
+	if *geoSmoothing == "off" || !loc.hasCoords() || loc.Approximated != "" {
+		return
+	}
+	var candidates []location
+	for _, db := range cityDBs() {
+		if other, err := lookupLocation(db, ip, o); err == nil && other.hasCoords() &&
+			other.Approximated == "" && other.CountryCode == loc.CountryCode {
+			candidates = append(candidates, other)
+		}
+	}
+	if len(candidates) < 2 {
+		return
+	}
+	var lat, long float64
+	switch *geoSmoothing {
+	case "centroid":
+		for _, c := range candidates {
+			lat, long = lat+*c.Latitude, long+*c.Longitude
+		}
+		lat, long = lat/float64(len(candidates)), long/float64(len(candidates))
+	case "confident":
+		best := candidates[0]
+		for _, c := range candidates[1:] {
+			// An accuracy radius of 0 is unknown, the least accurate
+			if c.AccuracyRadius != 0 && (best.AccuracyRadius == 0 || c.AccuracyRadius < best.AccuracyRadius) {
+				best = c
+			}
+		}
+		lat, long, loc.AccuracyRadius = *best.Latitude, *best.Longitude, best.AccuracyRadius
+	}
+	loc.Latitude, loc.Longitude, loc.Smoothed = &lat, &long, *geoSmoothing
+	loc.roundCoords()
+	if loc.Geohash != "" {
+		loc.Geohash = geohash(*loc.Latitude, *loc.Longitude, o.geohash)
+	}
+}
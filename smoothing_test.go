@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestSmoothingMissingCoords(t *testing.T) {
+	defer func(smoothing, mode string) { *geoSmoothing, *missingCoords = smoothing, mode }(*geoSmoothing, *missingCoords)
+	*geoSmoothing, *missingCoords = "centroid", "zero"
+	sparse := &database{name: "sparse", file: new(string)}
+	*sparse.file = "testdata/city-sparse.mmdb"
+	if err := sparse.load(); err != nil {
+		t.Fatal(err)
+	}
+	defer sparse.reader().Close()
+	defer func(dbs []*database) { geoFallbacks = dbs }(geoFallbacks)
+	geoFallbacks = []*database{sparse}
+
+	// The placeholder of the fallback database doesn't pull 8.8.8.8 to 0,0
+	loc, err := getLocation("8.8.8.8", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loc.hasCoords() {
+		t.Fatalf("getLocation(8.8.8.8) = %+v, want coordinates", loc)
+	}
+	if loc.Smoothed != "" || *loc.Latitude != 37.386 || *loc.Longitude != -122.0838 {
+		t.Errorf("getLocation(8.8.8.8) = %v, %v (smoothed %q); want the coordinates of city.mmdb as is", *loc.Latitude, *loc.Longitude, loc.Smoothed)
+	}
+	// 9.9.9.9 is taken from the fallback database, having a city, and isn't
+	// pulled to the placeholder of city.mmdb either
+	loc, err = getLocation("9.9.9.9", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loc.hasCoords() {
+		t.Fatalf("getLocation(9.9.9.9) = %+v, want coordinates", loc)
+	}
+	if loc.Smoothed != "" || *loc.Latitude != 50.11 || *loc.Longitude != 8.68 {
+		t.Errorf("getLocation(9.9.9.9) = %v, %v (smoothed %q); want the coordinates of city-sparse.mmdb as is", *loc.Latitude, *loc.Longitude, loc.Smoothed)
+	}
+}
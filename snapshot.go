@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// snap, when loaded, holds precomputed results served instead of querying the
+// databases for the IPs it knows about.
+var snap *snapshot
+
+type snapshot struct {
+	Lang    string
+	Results map[string]ipinfo
+}
+
+func loadSnapshot(file string) (*snapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var s snapshot
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// get returns the precomputed result for ip, as long as it was generated in
+// the requested language. Coordinates are rounded as configured, regardless
+// of the precision the snapshot was generated with, on a copy of the location:
+// results are shared by concurrent requests.
+func (s *snapshot) get(ip, lang string) (ipinfo, bool) {
+	ipaddr := net.ParseIP(ip)
+	if s == nil || ipaddr == nil || lang != s.Lang {
+		return ipinfo{}, false
+	}
+	info, ok := s.Results[ipaddr.String()]
+	if info.Location != nil {
+		loc := *info.Location
+		loc.roundCoords()
+		info.Location = &loc
+	}
+	return info, ok
+}
+
+// snapshotable reports whether results precomputed with the default options
+// answer requests with o: the options the results depend on (those of their
+// cache key) must be the default ones, except for the language, checked by get,
+// and the redacted fields and fingerprint, applied to the results served.
+// Debugging, explanations and local times are never precomputed.
+func snapshotable(o options) bool {
+	if o.debug || o.explain || o.localTime {
+		return false
+	}
+	d := defaultOptions()
+	d.lang, d.langs = o.lang, []string{o.lang}
+	d.redacted, d.fingerprint = o.redacted, o.fingerprint
+	return cacheKey("", "", o) == cacheKey("", "", d)
+}
+
+// generateSnapshot looks up every IP listed in r (one per line, blank lines
+// and #comments ignored) and writes the resulting snapshot to w. IPs absent
+// from the databases are left out.
+func generateSnapshot(r io.Reader, w io.Writer) error {
+	o := defaultOptions()
+	s := snapshot{Lang: o.lang, Results: make(map[string]ipinfo)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		info, err := getIPInfo(line, o)
+		if errors.Is(err, errNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		s.Results[info.IP.String()] = info
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(s)
+}
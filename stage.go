@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stagedResult compares the raw records of a probe IP in a staged database and
+// in the live one it is a candidate for.
+type stagedResult struct {
+	Staged  rawRecord
+	Live    rawRecord
+	Changed bool
+}
+
+// stageHandler is a dry run of a reload: it opens the candidate file given by
+// ?path= for the database named by ?db=, checks it as self-checks do, looks up
+// the -stage_probe_ips (or those of ?ips=) in it and in the live database, and
+// closes it. The live readers are left untouched, for operators to validate a
+// database update before promoting it.
+func stageHandler(c *gin.Context) {
+	name := c.Query("db")
+	db := databaseNamed(name)
+	if db == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown database %q", name), "code": "not_found"})
+		return
+	}
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing path of the candidate database file", "code": "bad_request"})
+		return
+	}
+	probes := *stageProbeIPs
+	if ips := c.Query("ips"); ips != "" {
+		probes = ips
+	}
+	var ips []net.IP
+	for _, s := range splitList(probes) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid ip address %q", s), "code": "bad_request"})
+			return
+		}
+		ips = append(ips, ip)
+	}
+
+	staged, err := openReader(path)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "invalid_database"})
+		return
+	}
+	defer staged.Close()
+	// Kept open for the comparisons, even if the database is reloaded meanwhile
+	live := db.pinnedReader()
+	if live != nil {
+		defer live.unpin()
+	}
+
+	results := make(map[string]stagedResult, len(ips))
+	for _, ip := range ips {
+		res := stagedResult{Staged: lookupRecord(staged, ip), Live: lookupRecord(live, ip)}
+		res.Changed = !reflect.DeepEqual(res.Staged, res.Live)
+		results[ip.String()] = res
+	}
+	body := gin.H{
+		"database": db.name,
+		"staged":   readerSource(path, staged.Reader),
+		"live":     db.source(),
+		"results":  results,
+	}
+	status := http.StatusOK
+	if err := db.probe(staged.Reader); err != nil {
+		status, body["error"], body["code"] = http.StatusUnprocessableEntity, err.Error(), "probe_failed"
+	}
+	c.JSON(status, body)
+}
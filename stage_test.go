@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStage(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/admin/stage?db=asn&path=testdata/asn-2024.mmdb&ips=8.8.8.8,1.1.1.1", nil)
+	stageHandler(c)
+	var body struct {
+		Results map[string]stagedResult
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("stage status = %d (%v), want %d", w.Code, err, http.StatusOK)
+	}
+	// Google LLC was Google Inc. in 2024, and 1.1.1.1 wasn't listed
+	if r := body.Results["8.8.8.8"]; !r.Changed || r.Live.Record == nil || r.Staged.Record == nil {
+		t.Errorf("staged 8.8.8.8 = %+v, want changed records", r)
+	}
+	if r := body.Results["1.1.1.1"]; !r.Changed || r.Staged.Record != nil {
+		t.Errorf("staged 1.1.1.1 = %+v, want a record removed", r)
+	}
+	// The live reader pinned for the comparisons is released
+	live := asnDB.reader()
+	live.mu.Lock()
+	defer live.mu.Unlock()
+	if live.pins != 0 {
+		t.Errorf("live reader left with %d pins, want none", live.pins)
+	}
+}
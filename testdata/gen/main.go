@@ -0,0 +1,145 @@
+// Command gen writes the fixture databases of the tests to its parent
+// directory (go generate, from the repository root). They are small, fake
+// GeoLite2/GeoIP2 databases, built at fixed dates for the output to be
+// reproducible.
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+const (
+	currentEpoch = 1760000000 // 2025-10-09
+	historyEpoch = 1710000000 // 2024-03-09
+)
+
+func names(m map[string]string) mmdbtype.Map {
+	out := mmdbtype.Map{}
+	for k, v := range m {
+		out[mmdbtype.String(k)] = mmdbtype.String(v)
+	}
+	return out
+}
+
+func write(file, typ string, ipVersion int, epoch int64, records map[string]mmdbtype.Map) {
+	w, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: typ, IPVersion: ipVersion, RecordSize: 24, IncludeReservedNetworks: true, BuildEpoch: epoch})
+	if err != nil {
+		log.Fatal(err)
+	}
+	networks := make([]string, 0, len(records))
+	for network := range records {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Insert(n, records[network]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	f, err := os.Create(filepath.Join("..", file))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := w.WriteTo(f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func city(continent, continentCode, country, countryCode, cityName string, lat, long float64, radius int) mmdbtype.Map {
+	m := mmdbtype.Map{
+		"continent":          mmdbtype.Map{"code": mmdbtype.String(continentCode), "names": names(map[string]string{"en": continent, "de": continent + "-de", "ja": continent + "-ja"})},
+		"country":            mmdbtype.Map{"iso_code": mmdbtype.String(countryCode), "names": names(map[string]string{"en": country, "de": country + "-de", "ja": country + "-ja", "zh-CN": country + "-zh"})},
+		"registered_country": mmdbtype.Map{"iso_code": mmdbtype.String(countryCode), "names": names(map[string]string{"en": country})},
+		"location":           mmdbtype.Map{"latitude": mmdbtype.Float64(lat), "longitude": mmdbtype.Float64(long), "accuracy_radius": mmdbtype.Uint16(radius), "time_zone": mmdbtype.String("America/Chicago")},
+		"subdivisions": mmdbtype.Slice{
+			mmdbtype.Map{"iso_code": mmdbtype.String("CA"), "names": names(map[string]string{"en": "California"})},
+			mmdbtype.Map{"iso_code": mmdbtype.String("SC"), "names": names(map[string]string{"en": "Santa Clara"})},
+		},
+		"postal": mmdbtype.Map{"code": mmdbtype.String("94043")},
+	}
+	if cityName != "" {
+		m["city"] = mmdbtype.Map{"names": names(map[string]string{"en": cityName, "ja": cityName + "-ja"})}
+	}
+	return m
+}
+
+func enterprise(c mmdbtype.Map, number uint32, org string) mmdbtype.Map {
+	c["traits"] = mmdbtype.Map{"autonomous_system_number": mmdbtype.Uint32(number), "autonomous_system_organization": mmdbtype.String(org)}
+	return c
+}
+
+func main() {
+	write("asn.mmdb", "GeoLite2-ASN", 6, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24":     {"autonomous_system_number": mmdbtype.Uint32(15169), "autonomous_system_organization": mmdbtype.String("Google LLC"), "country_code": mmdbtype.String("us")},
+		"1.1.1.0/24":     {"autonomous_system_number": mmdbtype.Uint32(13335), "autonomous_system_organization": mmdbtype.String("CLOUDFLARENET")},
+		"2001:4860::/32": {"autonomous_system_number": mmdbtype.Uint32(15169), "autonomous_system_organization": mmdbtype.String("Google LLC")},
+		"9.9.9.0/24":     {"autonomous_system_number": mmdbtype.Uint32(0), "autonomous_system_organization": mmdbtype.String("")},
+		"4.4.4.0/24":     {"autonomous_system_number": mmdbtype.Uint32(0), "autonomous_system_organization": mmdbtype.String("Unnumbered")},
+		"5.5.0.0/16":     {"autonomous_system_number": mmdbtype.Uint32(4200000001), "autonomous_system_organization": mmdbtype.String("Private 4-byte, Inc.")},
+	})
+	write("asn-v4.mmdb", "GeoLite2-ASN", 4, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": {"autonomous_system_number": mmdbtype.Uint32(15169), "autonomous_system_organization": mmdbtype.String("GOOGLE")},
+	})
+	write("city.mmdb", "GeoLite2-City", 6, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24":     city("North America", "NA", "United States", "US", "Mountain View", 37.386, -122.0838, 1000),
+		"1.1.1.0/24":     city("Oceania", "OC", "Australia", "AU", "", -33.494, 143.2104, 5),
+		"7.7.7.0/24":     city("Europe", "EU", "France", "FR", "", 48.80, 2.13, 20),
+		"2001:4860::/32": city("North America", "NA", "United States", "US", "Mountain View", 37.386, -122.0838, 100),
+		"6.6.6.0/24": func() mmdbtype.Map {
+			m := city("Europe", "EU", "Ukraine", "UA", "Simferopol", 44.95, 34.1, 50)
+			m["registered_country"] = mmdbtype.Map{"iso_code": mmdbtype.String("RU"), "names": names(map[string]string{"en": "Russia"})}
+			m["represented_country"] = mmdbtype.Map{"iso_code": mmdbtype.String("US"), "type": mmdbtype.String("military"), "names": names(map[string]string{"en": "United States"})}
+			return m
+		}(),
+		"9.9.9.0/24": {"continent": mmdbtype.Map{"code": mmdbtype.String("EU"), "names": mmdbtype.Map{}}, "country": mmdbtype.Map{"iso_code": mmdbtype.String("DE"), "names": mmdbtype.Map{}}},
+	})
+	write("enterprise.mmdb", "GeoIP2-Enterprise", 6, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": func() mmdbtype.Map {
+			m := enterprise(city("North America", "NA", "United States", "US", "Ashburn", 39.04, -77.48, 10), 15169, "GOOGLE LLC")
+			m["traits"].(mmdbtype.Map)["user_type"] = mmdbtype.String("hosting")
+			m["traits"].(mmdbtype.Map)["static_ip_score"] = mmdbtype.Float64(0)
+			return m
+		}(),
+		"7.7.7.0/24": func() mmdbtype.Map {
+			m := enterprise(city("North America", "NA", "United States", "US", "Columbus", 39.96, -83.0, 20), 3356, "LEVEL3")
+			m["country"].(mmdbtype.Map)["confidence"] = mmdbtype.Uint16(80)
+			return m
+		}(),
+	})
+	write("anon.mmdb", "GeoIP2-Anonymous-IP", 6, currentEpoch, map[string]mmdbtype.Map{
+		"1.1.1.0/24": {"is_anonymous": mmdbtype.Bool(true), "is_anonymous_vpn": mmdbtype.Bool(true), "is_hosting_provider": mmdbtype.Bool(true)},
+	})
+	write("domain.mmdb", "GeoIP2-Domain", 6, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": {"domain": mmdbtype.String("google.com")},
+	})
+	write("isp.mmdb", "GeoIP2-ISP", 6, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": {"autonomous_system_number": mmdbtype.Uint32(15169), "autonomous_system_organization": mmdbtype.String("Google LLC"), "isp": mmdbtype.String("Google"), "organization": mmdbtype.String("Google Public DNS")},
+	})
+	write("asn-2024.mmdb", "GeoLite2-ASN", 6, historyEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": {"autonomous_system_number": mmdbtype.Uint32(15169), "autonomous_system_organization": mmdbtype.String("Google Inc.")},
+	})
+	// Coordinates where city.mmdb has none, and none where it has some
+	write("city-sparse.mmdb", "GeoLite2-City", 6, currentEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": func() mmdbtype.Map {
+			m := city("North America", "NA", "United States", "US", "Mountain View", 0, 0, 0)
+			delete(m, "location")
+			return m
+		}(),
+		"9.9.9.0/24": city("Europe", "EU", "Germany", "DE", "Frankfurt am Main", 50.11, 8.68, 20),
+	})
+	write("city-2024.mmdb", "GeoLite2-City", 6, historyEpoch, map[string]mmdbtype.Map{
+		"8.8.8.0/24": city("North America", "NA", "United States", "US", "Ashburn", 39.04, -77.48, 10),
+	})
+}
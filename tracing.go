@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is a no-op unless tracing was set up.
+var tracer = otel.Tracer("github.com/da-rod/ipinfo")
+
+// setupTracing exports spans to an OTLP/HTTP collector at endpoint (host:port).
+func setupTracing(endpoint string) error {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("ipinfo"))),
+	))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// traceRequest starts a span per request, continuing the caller's trace if any.
+func traceRequest(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(
+		semconv.HTTPMethodKey.String(c.Request.Method),
+		semconv.HTTPRouteKey.String(route),
+		semconv.HTTPStatusCodeKey.Int(status),
+		semconv.NetPeerIPKey.String(traceIP(clientIP(c))),
+	)
+	if status >= 500 {
+		span.SetStatus(codes.Error, strconv.Itoa(status))
+	}
+}
+
+// traceIP is s as span attributes have it: truncated to its network with
+// -trace_anonymize, when an IP.
+func traceIP(s string) string {
+	ip := net.ParseIP(s)
+	if !*traceAnonymize || ip == nil {
+		return s
+	}
+	return truncateIP(ip)
+}
+
+// track starts timing and tracing the database read of ip under name; the
+// returned function ends both.
+func (o options) track(name string, db *database, ip string) func(error) {
+	start := time.Now()
+	_, span := tracer.Start(o.ctx, name+" lookup", trace.WithAttributes(
+		attribute.String("ipinfo.ip", traceIP(ip)),
+		attribute.String("ipinfo.database", db.name),
+	))
+	return func(err error) {
+		if o.timings != nil {
+			o.debugMu.Lock()
+			o.timings[name] += time.Since(start).Seconds()
+			o.debugMu.Unlock()
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceIP(t *testing.T) {
+	defer func(b bool) { *traceAnonymize = b }(*traceAnonymize)
+	tests := []struct {
+		ip, anonymized string
+	}{
+		{"8.8.8.8", "8.8.8.0"},
+		{"::ffff:8.8.8.8", "8.8.8.0"},
+		{"2001:4860:4860::8888", "2001:4860:4860::"},
+		{"notanip", "notanip"},
+	}
+	for _, tt := range tests {
+		*traceAnonymize = true
+		if got := traceIP(tt.ip); got != tt.anonymized {
+			t.Errorf("traceIP(%q) = %q, want %q", tt.ip, got, tt.anonymized)
+		}
+		*traceAnonymize = false
+		if got := traceIP(tt.ip); got != tt.ip {
+			t.Errorf("traceIP(%q) without -trace_anonymize = %q, want it unchanged", tt.ip, got)
+		}
+	}
+}
+
+func TestLookupSpanIP(t *testing.T) {
+	defer func(b bool) { *traceAnonymize = b }(*traceAnonymize)
+	*traceAnonymize = true
+	recorder := tracetest.NewSpanRecorder()
+	defer func(previous trace.Tracer) { tracer = previous }(tracer)
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	o := testOptions()
+	o.ctx = context.Background()
+	if _, err := getAS("8.8.8.8", o); err != nil {
+		t.Fatal(err)
+	}
+	spans := recorder.Ended()
+	if len(spans) == 0 {
+		t.Fatal("no span of the lookup")
+	}
+	var ip string
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "ipinfo.ip" {
+			ip = attr.Value.AsString()
+		}
+	}
+	if ip != "8.8.8.0" {
+		t.Errorf("span attribute ipinfo.ip = %q, want the 8.8.8.0 network", ip)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// warmupIPs are looked up in every database as it is loaded, before it
+// serves any lookup, to fault in the pages of the file the traffic of the
+// deployment touches; none when nil.
+var warmupIPs []net.IP
+
+// warmup is how long the warmup of a reader took, reported by /db/info.
+type warmup struct {
+	IPs     int
+	Seconds float64
+}
+
+// loadWarmupIPs reads the IPs of file, one per line (blank lines and
+// #comments ignored), along with the comma-separated ones of list.
+func loadWarmupIPs(file, list string) ([]net.IP, error) {
+	lines := splitList(list)
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fromFile, err := readLines(f, -1)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fromFile...)
+	}
+	ips := make([]net.IP, 0, len(lines))
+	for _, line := range lines {
+		ip := net.ParseIP(line)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid warmup ip address %q", line)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// warm looks the warmup IPs up in r, decoding their whole records.
+func (db *database) warm(r *dbReader) {
+	if len(warmupIPs) == 0 {
+		return
+	}
+	start := time.Now()
+	for _, ip := range warmupIPs {
+		var record interface{}
+		r.mmdb.Lookup(ip, &record)
+	}
+	r.warmup = &warmup{IPs: len(warmupIPs), Seconds: time.Since(start).Seconds()}
+	log.Printf("%s database warmed up with %d ips in %v", db.name, len(warmupIPs), time.Since(start))
+}